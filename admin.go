@@ -0,0 +1,246 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/websocket"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	AdminLimit    = 100  // default page size of the admin connection listing
+	AdminMaxLimit = 1000 // largest page size of the admin connection listing
+)
+
+// ErrNotSupported is returned by admin endpoints the subscription store cannot serve.
+var ErrNotSupported = errors.New("not supported by the subscription store")
+
+// AdminAuth authorizes an admin request, an error refuses it with 401.
+type AdminAuth func(r *http.Request) error
+
+// ConnectionInfo describes a live connection.
+type ConnectionInfo struct {
+	ID            string   `json:"id"`
+	RemoteAddr    string   `json:"remote_addr"`
+	UserID        string   `json:"user_id,omitempty"`
+	Protocol      string   `json:"protocol"`
+	FirstTime     int64    `json:"first_time"`
+	LastTime      int64    `json:"last_time"`
+	QueueLength   int      `json:"queue_length"`
+	QueueBytes    int      `json:"queue_bytes"`
+	Dropped       uint64   `json:"dropped"`
+	Subscriptions []string `json:"subscriptions,omitempty"`
+}
+
+// ChannelInfo describes a channel of the subscription store.
+type ChannelInfo struct {
+	Channel     string `json:"channel"`
+	Subscribers int    `json:"subscribers"`
+}
+
+// Admin returns the introspection API of the engine as an http.Handler:
+//
+//	GET    /stats                      engine counters
+//	GET    /connections                list connections, filtered by ?user= and ?q=, paged by ?limit= (up to AdminMaxLimit) and ?offset=
+//	GET    /connections/{id}           connection detail
+//	DELETE /connections/{id}           kick a connection, with optional ?code= (a close code) and ?reason=
+//	GET    /channels                   channels with their subscriber counts
+//	POST   /channels/{channel}/publish publish the request body, as an envelope when ?command= is set
+//
+// Mount it under a prefix with http.StripPrefix. Every request goes through
+// auth first; a nil auth leaves the API open, only do that on a trusted network.
+func (e *Engine) Admin(auth AdminAuth) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /stats", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, e.Stats())
+	})
+	mux.HandleFunc("GET /connections", e.adminConnections)
+	mux.HandleFunc("GET /connections/{id}", e.adminConnection)
+	mux.HandleFunc("DELETE /connections/{id}", e.adminKick)
+	mux.HandleFunc("GET /channels", e.adminChannels)
+	mux.HandleFunc("POST /channels/{channel}/publish", e.adminPublish)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if auth != nil {
+			if err := auth(r); err != nil {
+				writeError(w, http.StatusUnauthorized, err)
+				return
+			}
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func (e *Engine) adminConnections(w http.ResponseWriter, r *http.Request) {
+	user, query := r.URL.Query().Get("user"), r.URL.Query().Get("q")
+	limit, err := queryInt(w, r, "limit", AdminLimit)
+	if err != nil {
+		return
+	}
+	offset, err := queryInt(w, r, "offset", 0)
+	if err != nil {
+		return
+	}
+
+	var clients []*Client
+	e.pool.Range(func(key, value any) bool {
+		client, ok := value.(*Client)
+		if !ok {
+			return true
+		}
+		userID := client.UserID()
+		if user != "" && userID != user {
+			return true
+		}
+		if query != "" && !strings.Contains(client.id, query) && !strings.Contains(userID, query) &&
+			!strings.Contains(client.RemoteAddr().String(), query) {
+			return true
+		}
+		clients = append(clients, client)
+		return true
+	})
+	sort.Slice(clients, func(i, j int) bool {
+		if clients[i].firstTime != clients[j].firstTime {
+			return clients[i].firstTime < clients[j].firstTime
+		}
+		return clients[i].id < clients[j].id
+	})
+
+	total := len(clients)
+	offset = min(offset, total)
+	clients = clients[offset : offset+min(limit, AdminMaxLimit, total-offset)]
+	connections := make([]ConnectionInfo, 0, len(clients))
+	for _, client := range clients {
+		connections = append(connections, e.connectionInfo(client))
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"total": total, "connections": connections})
+}
+
+func (e *Engine) adminConnection(w http.ResponseWriter, r *http.Request) {
+	client, err := e.getClient(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, e.connectionInfo(client))
+}
+
+func (e *Engine) adminKick(w http.ResponseWriter, r *http.Request) {
+	code, err := queryInt(w, r, "code", 0)
+	if err != nil {
+		return
+	}
+	if code != 0 && !validCloseCode(code) {
+		writeError(w, http.StatusBadRequest, errors.New("code must be a close code between 1000 and 4999"))
+		return
+	}
+	reason := r.URL.Query().Get("reason")
+	if reason == "" {
+		reason = "kicked by admin"
+	}
+	if err = e.Kick(r.PathValue("id"), code, reason); err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (e *Engine) adminChannels(w http.ResponseWriter, r *http.Request) {
+	lister, ok := e.storage.(ChannelLister)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, ErrNotSupported)
+		return
+	}
+	counts, err := lister.Channels()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	channels := make([]ChannelInfo, 0, len(counts))
+	for channel, subscribers := range counts {
+		channels = append(channels, ChannelInfo{Channel: channel, Subscribers: subscribers})
+	}
+	sort.Slice(channels, func(i, j int) bool {
+		return channels[i].Channel < channels[j].Channel
+	})
+	writeJSON(w, http.StatusOK, map[string]any{"channels": channels})
+}
+
+func (e *Engine) adminPublish(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	channel := r.PathValue("channel")
+	if command := r.URL.Query().Get("command"); command != "" {
+		err = e.PublishMessageContext(r.Context(), channel, command, body)
+	} else {
+		err = e.PublishContext(r.Context(), channel, body)
+	}
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// connectionInfo snapshots client, with its subscriptions when the store can list them.
+func (e *Engine) connectionInfo(client *Client) ConnectionInfo {
+	length, bytes, dropped := client.QueueStats()
+	info := ConnectionInfo{
+		ID:          client.id,
+		RemoteAddr:  client.RemoteAddr().String(),
+		UserID:      client.UserID(),
+		Protocol:    codec(client.Protocol()),
+		FirstTime:   client.firstTime,
+		LastTime:    client.lastTime.Load(),
+		QueueLength: length,
+		QueueBytes:  bytes,
+		Dropped:     dropped,
+	}
+	if lister, ok := e.storage.(SubscriptionLister); ok {
+		info.Subscriptions, _ = lister.Subscriptions(client.id)
+	}
+	return info
+}
+
+// validCloseCode reports whether a close frame may carry code: 1000 to 4999
+// except the codes reserved for endpoints that never send them.
+func validCloseCode(code int) bool {
+	switch code {
+	case websocket.CloseNoStatusReceived, websocket.CloseAbnormalClosure, websocket.CloseTLSHandshake, 1004:
+		return false
+	}
+	return code >= websocket.CloseNormalClosure && code <= 4999
+}
+
+// queryInt reads a non-negative integer query parameter, replying 400 when it is invalid.
+func queryInt(w http.ResponseWriter, r *http.Request, key string, value int) (int, error) {
+	if !r.URL.Query().Has(key) {
+		return value, nil
+	}
+	n, err := strconv.Atoi(r.URL.Query().Get(key))
+	if err == nil && n < 0 {
+		err = errors.New(key + " must not be negative")
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+	}
+	return n, err
+}
+
+// writeJSON replies status with v as JSON.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeError replies status with err as a JSON error.
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,113 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	ws "github.com/gorilla/websocket"
+)
+
+func TestAdmin(t *testing.T) {
+	e := newTestEngine(WithOnConnect(func(c *Client, r *http.Request) error {
+		c.SetUser(r.URL.Query().Get("user"))
+		return nil
+	}))
+	mux := http.NewServeMux()
+	mux.Handle("/ws", e)
+	mux.Handle("/admin/", http.StripPrefix("/admin", e.Admin(func(r *http.Request) error {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			return errors.New("bad token")
+		}
+		return nil
+	})))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+
+	var ids []string
+	for _, user := range []string{"alice", "bob"} {
+		conn, _, err := ws.DefaultDialer.Dial(url+"?user="+user, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		var greeting JsonMessage
+		if err = conn.ReadJSON(&greeting); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, greeting.SocketId)
+	}
+	if err := e.Subscribe(ids[0], "news"); err != nil {
+		t.Fatal(err)
+	}
+
+	call := func(method, path, token string) (int, []byte) {
+		req, _ := http.NewRequest(method, srv.URL+"/admin"+path, strings.NewReader(`"hello"`))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var body json.RawMessage
+		_ = json.NewDecoder(resp.Body).Decode(&body)
+		return resp.StatusCode, body
+	}
+
+	if status, _ := call(http.MethodGet, "/stats", "wrong"); status != http.StatusUnauthorized {
+		t.Fatalf("unauthorized stats = %d", status)
+	}
+
+	status, body := call(http.MethodGet, "/connections?user=alice", "secret")
+	var list struct {
+		Total       int              `json:"total"`
+		Connections []ConnectionInfo `json:"connections"`
+	}
+	_ = json.Unmarshal(body, &list)
+	if status != http.StatusOK || list.Total != 1 || list.Connections[0].ID != ids[0] {
+		t.Fatalf("connections = %d %s", status, body)
+	}
+	if subs := list.Connections[0].Subscriptions; len(subs) != 1 || subs[0] != "news" {
+		t.Errorf("subscriptions = %v", subs)
+	}
+
+	status, body = call(http.MethodGet, "/connections?limit=1&offset=1", "secret")
+	_ = json.Unmarshal(body, &list)
+	if status != http.StatusOK || list.Total != 2 || len(list.Connections) != 1 {
+		t.Errorf("paged connections = %d %s", status, body)
+	}
+
+	for _, query := range []string{"limit=9223372036854775807&offset=1", "limit=1&offset=9223372036854775807"} {
+		status, body = call(http.MethodGet, "/connections?"+query, "secret")
+		list.Connections = nil
+		_ = json.Unmarshal(body, &list)
+		if status != http.StatusOK || list.Total != 2 || len(list.Connections) > 1 {
+			t.Errorf("connections?%s = %d %s", query, status, body)
+		}
+	}
+	for _, code := range []string{"999", "1004", "1005", "1006", "1015", "5000"} {
+		if status, body = call(http.MethodDelete, "/connections/"+ids[1]+"?code="+code, "secret"); status != http.StatusBadRequest {
+			t.Errorf("kick with code %s = %d %s", code, status, body)
+		}
+	}
+
+	status, body = call(http.MethodGet, "/channels", "secret")
+	if status != http.StatusOK || !strings.Contains(string(body), `{"channel":"news","subscribers":1}`) {
+		t.Errorf("channels = %d %s", status, body)
+	}
+	if status, body = call(http.MethodPost, "/channels/news/publish?command=notice", "secret"); status != http.StatusAccepted {
+		t.Errorf("publish = %d %s", status, body)
+	}
+	if status, _ = call(http.MethodDelete, "/connections/"+ids[1]+"?code=4000", "secret"); status != http.StatusNoContent {
+		t.Errorf("kick = %d", status)
+	}
+	if status, _ = call(http.MethodGet, "/connections/missing", "secret"); status != http.StatusNotFound {
+		t.Errorf("missing connection = %d", status)
+	}
+}
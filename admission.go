@@ -0,0 +1,131 @@
+package websocket
+
+import (
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const RetryAfter = 5 * time.Second // default Retry-After of refused upgrades
+
+var (
+	ErrMaxConn       = errors.New("websocket service connections exceeded the upper limit")
+	ErrMaxConnPerIP  = errors.New("too many connections from this address")
+	ErrConnRateLimit = errors.New("too many connection attempts")
+	ErrUnauthorized  = errors.New("unauthorized")
+)
+
+// UserResolver authenticates an upgrade request and returns its user id, an
+// empty id leaves the connection anonymous.
+type UserResolver func(r *http.Request) (userID string, err error)
+
+// admissionError refuses an upgrade request with an HTTP status.
+type admissionError struct {
+	status     int
+	retryAfter time.Duration
+	err        error
+}
+
+func (a *admissionError) Error() string {
+	return a.err.Error()
+}
+
+func (a *admissionError) Unwrap() error {
+	return a.err
+}
+
+// write sends the refusal, with a Retry-After header for temporary refusals.
+func (a *admissionError) write(w http.ResponseWriter) {
+	if a.retryAfter > 0 {
+		seconds := int(math.Ceil(a.retryAfter.Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	}
+	http.Error(w, a.err.Error(), a.status)
+}
+
+// unavailable refuses an upgrade with 503 Service Unavailable.
+func (e *Engine) unavailable(err error) *admissionError {
+	return &admissionError{status: http.StatusServiceUnavailable, retryAfter: e.retryAfter, err: err}
+}
+
+// admission holds the connection slots reserved before upgrading.
+type admission struct {
+	mux   *sync.Mutex
+	slots uint32
+	perIP map[string]int
+	rate  *tokenBucket // connection attempts rate limit
+}
+
+func newAdmission() *admission {
+	return &admission{
+		mux:   new(sync.Mutex),
+		perIP: make(map[string]int),
+		rate:  newTokenBucket(&limitConfig{}),
+	}
+}
+
+// admit checks the upgrade request against the shutdown state, the connection
+// rate limit, resolver and the connection caps, and reserves a slot for it.
+// The returned user id is empty for anonymous connections.
+func (e *Engine) admit(r *http.Request, ip string, resolver UserResolver) (userID string, err error) {
+	if e.isClosing() {
+		return "", e.unavailable(ErrEngineClosed)
+	}
+	if _, ok := e.admission.rate.allow(e.clock.Now()); !ok {
+		return "", e.unavailable(ErrConnRateLimit)
+	}
+	if resolver != nil {
+		if userID, err = resolver(r); err != nil {
+			return "", &admissionError{status: http.StatusUnauthorized, err: err}
+		}
+	}
+	if userID != "" && e.users.limit > 0 && e.users.policy == RejectNewest && len(e.users.clients(userID)) >= e.users.limit {
+		return "", e.unavailable(ErrUserConnLimit)
+	}
+	if err = e.reserve(ip); err != nil {
+		return "", e.unavailable(err)
+	}
+	return
+}
+
+// reserve takes a connection slot for ip, checking the global and per-IP caps.
+func (e *Engine) reserve(ip string) error {
+	a := e.admission
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	if e.maxConn > 0 && a.slots >= e.maxConn {
+		return ErrMaxConn
+	}
+	if e.maxConnPerIP > 0 && a.perIP[ip] >= e.maxConnPerIP {
+		return ErrMaxConnPerIP
+	}
+	a.slots++
+	a.perIP[ip]++
+	return nil
+}
+
+// unreserve gives back the slot taken by reserve.
+func (e *Engine) unreserve(ip string) {
+	a := e.admission
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	a.slots--
+	if a.perIP[ip]--; a.perIP[ip] <= 0 {
+		delete(a.perIP, ip)
+	}
+}
+
+// remoteIP returns the host part of the request remote address.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
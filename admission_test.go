@@ -0,0 +1,124 @@
+package websocket
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	ws "github.com/gorilla/websocket"
+)
+
+func dialAdmission(t *testing.T, e *Engine) (string, func()) {
+	mux := http.NewServeMux()
+	mux.Handle("/ws", e)
+	srv := httptest.NewServer(mux)
+	return "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws", srv.Close
+}
+
+func TestAdmissionMaxConn(t *testing.T) {
+	e := newTestEngine(WithMaxConn(2), WithRetryAfter(3*time.Second))
+	url, stop := dialAdmission(t, e)
+	defer stop()
+
+	var conns []*ws.Conn
+	for i := 0; i < 2; i++ {
+		conn, _, err := ws.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			t.Fatalf("dial %d: %v", i, err)
+		}
+		conns = append(conns, conn)
+	}
+	_, resp, err := ws.DefaultDialer.Dial(url, nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %v %v", resp, err)
+	}
+	if got := resp.Header.Get("Retry-After"); got != "3" {
+		t.Errorf("Retry-After = %q, want 3", got)
+	}
+	if got := e.Stats().Rejected; got != 1 {
+		t.Errorf("Rejected = %d, want 1", got)
+	}
+
+	_ = conns[0].Close()
+	for i := 0; ; i++ {
+		conn, _, err := ws.DefaultDialer.Dial(url, nil)
+		if err == nil {
+			_ = conn.Close()
+			break
+		}
+		if i == 100 {
+			t.Fatal("slot was not given back after close")
+		}
+	}
+}
+
+func TestAdmissionUnlimitedByDefault(t *testing.T) {
+	e := newTestEngine()
+	url, stop := dialAdmission(t, e)
+	defer stop()
+
+	for i := 0; i < 5; i++ {
+		conn, _, err := ws.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			t.Fatalf("dial %d: %v", i, err)
+		}
+		defer conn.Close()
+	}
+}
+
+func TestAdmissionPerIPAndRate(t *testing.T) {
+	e := newTestEngine(WithMaxConnPerIP(1))
+	if err := e.reserve("10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.reserve("10.0.0.1"); !errors.Is(err, ErrMaxConnPerIP) {
+		t.Fatalf("expected ErrMaxConnPerIP, got %v", err)
+	}
+	if err := e.reserve("10.0.0.2"); err != nil {
+		t.Fatal(err)
+	}
+	e.unreserve("10.0.0.1")
+	if err := e.reserve("10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	e = newTestEngine(WithConnRateLimit(1, 1))
+	r := httptest.NewRequest(http.MethodGet, "/ws", nil)
+	if _, err := e.admit(r, "10.0.0.1", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.admit(r, "10.0.0.1", nil); !errors.Is(err, ErrConnRateLimit) {
+		t.Fatalf("expected ErrConnRateLimit, got %v", err)
+	}
+}
+
+func TestAdmissionUserResolver(t *testing.T) {
+	e := newTestEngine(WithMaxConnPerUser(1, RejectNewest), WithUserResolver(func(r *http.Request) (string, error) {
+		if token := r.URL.Query().Get("token"); token != "" {
+			return token, nil
+		}
+		return "", ErrUnauthorized
+	}))
+	url, stop := dialAdmission(t, e)
+	defer stop()
+
+	_, resp, _ := ws.DefaultDialer.Dial(url, nil)
+	if resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %v", resp)
+	}
+	conn, _, err := ws.DefaultDialer.Dial(url+"?token=alice", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, _, err = conn.ReadMessage(); err != nil {
+		t.Fatal(err)
+	}
+	_, resp, _ = ws.DefaultDialer.Dial(url+"?token=alice", nil)
+	if resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %v", resp)
+	}
+}
@@ -0,0 +1,137 @@
+package websocket
+
+import (
+	"encoding/binary"
+	"github.com/gorilla/websocket"
+	"io"
+	"time"
+)
+
+const BatchBytes = 32 * 1024 // default batch size threshold
+
+// BatchQuery is the query parameter of the upgrade request by which a client
+// opts in to the batching of WithBatching, e.g. ws://host/ws?batch=true.
+const BatchQuery = "batch"
+
+// maxPrefixed bounds the messages of binary batches: their length prefix then
+// starts with a zero byte, which tells a batch from a proto message, whose
+// first byte is a tag and never zero.
+const maxPrefixed = 1 << 24
+
+// batcher collects queued messages into a single data frame for clients that
+// opted in with WithBatching. Text batches are JSON arrays of the messages,
+// binary batches are the messages each prefixed with its big-endian uint32
+// length. A lone message is written as is, and so are binary messages of
+// maxPrefixed bytes or more.
+type batcher struct {
+	maxBytes int           // flush once the batch reaches this size
+	maxDelay time.Duration // flush at the latest this long after the first message, 0 flushes when the queue is empty
+	messages [][]byte
+	size     int
+	timer    *time.Timer
+}
+
+func newBatcher(maxBytes int, maxDelay time.Duration) *batcher {
+	if maxBytes <= 0 {
+		maxBytes = BatchBytes
+	}
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	return &batcher{
+		maxBytes: maxBytes,
+		maxDelay: maxDelay,
+		timer:    timer,
+	}
+}
+
+// add appends a message and reports whether the batch reached maxBytes.
+func (b *batcher) add(message []byte) bool {
+	if len(b.messages) == 0 && b.maxDelay > 0 {
+		b.timer.Reset(b.maxDelay)
+	}
+	b.messages = append(b.messages, message)
+	b.size += len(message)
+	return b.size >= b.maxBytes
+}
+
+// expired fires when the batch has waited maxDelay, nil for clients without batching.
+func (b *batcher) expired() <-chan time.Time {
+	if b == nil {
+		return nil
+	}
+	return b.timer.C
+}
+
+// writeTo writes the pending batch as one frame of messageType and resets it.
+func (b *batcher) writeTo(conn *websocket.Conn, messageType int) (messages [][]byte, err error) {
+	if len(b.messages) == 0 {
+		return
+	}
+	messages = b.messages
+	b.messages, b.size = nil, 0
+	b.timer.Stop()
+
+	if len(messages) == 1 || messageType == websocket.BinaryMessage && !prefixable(messages) {
+		for _, message := range messages {
+			if err = conn.WriteMessage(messageType, message); err != nil {
+				return
+			}
+		}
+		return
+	}
+	w, err := conn.NextWriter(messageType)
+	if err != nil {
+		return
+	}
+	if messageType == websocket.TextMessage {
+		err = writeJSONArray(w, messages)
+	} else {
+		err = writeLengthPrefixed(w, messages)
+	}
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	return
+}
+
+// prefixable reports whether every message fits a binary batch.
+func prefixable(messages [][]byte) bool {
+	for _, message := range messages {
+		if len(message) >= maxPrefixed {
+			return false
+		}
+	}
+	return true
+}
+
+func writeJSONArray(w io.Writer, messages [][]byte) error {
+	if _, err := w.Write([]byte{'['}); err != nil {
+		return err
+	}
+	for i, message := range messages {
+		if i > 0 {
+			if _, err := w.Write([]byte{','}); err != nil {
+				return err
+			}
+		}
+		if _, err := w.Write(message); err != nil {
+			return err
+		}
+	}
+	_, err := w.Write([]byte{']'})
+	return err
+}
+
+func writeLengthPrefixed(w io.Writer, messages [][]byte) error {
+	var prefix [4]byte
+	for _, message := range messages {
+		binary.BigEndian.PutUint32(prefix[:], uint32(len(message)))
+		if _, err := w.Write(prefix[:]); err != nil {
+			return err
+		}
+		if _, err := w.Write(message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+package websocket
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/protobuf/proto"
+)
+
+var benchPayload = []byte(`{"request_id":"1","socket_id":"1","command":"tick","data":"AAAAAAAAAAAAAAAAAAAAAAAA"}`)
+
+// dialBench starts an engine behind an httptest server and returns the server
+// side client, the dialed connection and a cleanup function.
+func dialBench(b *testing.B, opts ...Option) (*Client, *websocket.Conn, func()) {
+	clients := make(chan *Client, 1)
+	engine := newTestEngine(WithMaxConn(1), WithOnConnect(func(client *Client, r *http.Request) error {
+		clients <- client
+		return nil
+	}))
+	mux := http.NewServeMux()
+	mux.Handle("/ws", engine.Handler(opts...))
+	server := httptest.NewServer(mux)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws?"+BatchQuery+"=true", nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if _, _, err = conn.ReadMessage(); err != nil { // connected
+		b.Fatal(err)
+	}
+	return <-clients, conn, func() {
+		_ = conn.Close()
+		server.Close()
+	}
+}
+
+// countMessages reads frames until want messages arrived, batches count for each of their messages.
+func countMessages(conn *websocket.Conn, want int, frames *atomic.Int64) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for received := 0; received < want; {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			frames.Add(1)
+			if data[0] != '[' {
+				received++
+				continue
+			}
+			var batch []json.RawMessage
+			_ = json.Unmarshal(data, &batch)
+			received += len(batch)
+		}
+	}()
+	return done
+}
+
+func benchmarkThroughput(b *testing.B, opts ...Option) {
+	client, conn, cleanup := dialBench(b, opts...)
+	defer cleanup()
+
+	var frames atomic.Int64
+	done := countMessages(conn, b.N, &frames)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = client.Send(benchPayload)
+	}
+	<-done
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "msg/s")
+	b.ReportMetric(float64(frames.Load())/float64(b.N), "frames/msg")
+}
+
+func BenchmarkWriteThroughput(b *testing.B) {
+	b.Run("unbatched", func(b *testing.B) {
+		benchmarkThroughput(b, WithSendLimit(1024))
+	})
+	b.Run("batched", func(b *testing.B) {
+		benchmarkThroughput(b, WithSendLimit(1024), WithBatching(BatchBytes, time.Millisecond))
+	})
+}
+
+// benchmarkRate sends 10k messages per second for one second per iteration.
+func benchmarkRate(b *testing.B, opts ...Option) {
+	const rate, perTick = 10000, 10
+	client, conn, cleanup := dialBench(b, opts...)
+	defer cleanup()
+
+	var frames atomic.Int64
+	done := countMessages(conn, rate*b.N, &frames)
+	start := cpuTime()
+	ticker := time.NewTicker(time.Second * perTick / rate)
+	defer ticker.Stop()
+	for sent := 0; sent < rate*b.N; sent += perTick {
+		<-ticker.C
+		for i := 0; i < perTick; i++ {
+			_ = client.Send(benchPayload)
+		}
+	}
+	<-done
+	b.ReportMetric(float64(cpuTime()-start)/float64(time.Millisecond)/float64(b.N), "cpu-ms/s")
+	b.ReportMetric(float64(frames.Load())/float64(b.N), "frames/s")
+}
+
+func BenchmarkWrite10kPerSecond(b *testing.B) {
+	b.Run("unbatched", func(b *testing.B) {
+		benchmarkRate(b)
+	})
+	b.Run("batched", func(b *testing.B) {
+		benchmarkRate(b, WithBatching(BatchBytes, 5*time.Millisecond))
+	})
+}
+
+// dialBatching connects to an engine whose clients have opts, opting in to
+// batching, and returns the server side client and the dialed connection.
+func dialBatching(t *testing.T, opts ...Option) (*Client, *websocket.Conn) {
+	return dialEngine(t, "?"+BatchQuery+"=true", opts...)
+}
+
+// dialEngine connects with query to an engine whose clients have opts and
+// returns the server side client and the dialed connection.
+func dialEngine(t *testing.T, query string, opts ...Option) (*Client, *websocket.Conn) {
+	e := newTestEngine(WithClientOptions(opts...))
+	url, clients := serveEngine(t, e)
+	t.Cleanup(func() {
+		_ = e.Shutdown(context.Background())
+	})
+	conn, _, err := websocket.DefaultDialer.Dial(url+query, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	return <-clients, conn
+}
+
+func readFrame(t *testing.T, conn *websocket.Conn) (int, []byte) {
+	t.Helper()
+	messageType, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return messageType, data
+}
+
+func TestBatchTextAfterDelay(t *testing.T) {
+	const delay = 30 * time.Millisecond
+	client, conn := dialBatching(t, WithBatching(BatchBytes, delay))
+
+	// the greeting is a batch of one, written as a plain message
+	var greeting JsonMessage
+	if _, data := readFrame(t, conn); json.Unmarshal(data, &greeting) != nil || greeting.Command != Connected {
+		t.Fatalf("greeting %q", data)
+	}
+
+	start := time.Now()
+	for i := range 3 {
+		if err := client.Send([]byte(`{"n":` + strconv.Itoa(i) + `}`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	messageType, data := readFrame(t, conn)
+	if elapsed := time.Since(start); elapsed < delay {
+		t.Fatalf("batch written after %s, before its delay", elapsed)
+	}
+	var batch []struct{ N int }
+	if messageType != websocket.TextMessage || json.Unmarshal(data, &batch) != nil || len(batch) != 3 ||
+		batch[0].N != 0 || batch[2].N != 2 {
+		t.Fatalf("batch %q", data)
+	}
+}
+
+func TestBatchBinaryLengthPrefixed(t *testing.T) {
+	client, conn := dialBatching(t, WithProtocol(websocket.BinaryMessage), WithBatching(BatchBytes, 10*time.Millisecond))
+
+	var greeting ProtoMessage
+	if _, data := readFrame(t, conn); data[0] == 0 || proto.Unmarshal(data, &greeting) != nil || greeting.Command != Connected {
+		t.Fatalf("greeting %x", data)
+	}
+
+	for _, command := range []string{"a", "b"} {
+		data, _ := proto.Marshal(&ProtoMessage{RequestId: "1", SocketId: client.ID(), Command: command})
+		if err := client.Send(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	messageType, data := readFrame(t, conn)
+	if messageType != websocket.BinaryMessage {
+		t.Fatalf("batch of type %d", messageType)
+	}
+	var commands []string
+	for len(data) > 0 {
+		if len(data) < 4 || int(binary.BigEndian.Uint32(data)) > len(data)-4 {
+			t.Fatalf("truncated batch %x", data)
+		}
+		n := binary.BigEndian.Uint32(data)
+		var message ProtoMessage
+		if err := proto.Unmarshal(data[4:4+n], &message); err != nil {
+			t.Fatal(err)
+		}
+		commands = append(commands, message.Command)
+		data = data[4+n:]
+	}
+	if strings.Join(commands, ",") != "a,b" {
+		t.Fatalf("batch of %q", commands)
+	}
+}
+
+func TestBatchFlushAtMaxBytes(t *testing.T) {
+	message := []byte(`{"padding":"` + strings.Repeat("x", 80) + `"}`)
+	greeting := len(buildConnectedResponse(websocket.TextMessage, strings.Repeat("0", 36)).toBytes())
+	client, conn := dialBatching(t, WithBatching(greeting+2*len(message), time.Hour))
+
+	// the greeting and the first message wait for the delay, the second one fills the batch
+	for range 2 {
+		if err := client.Send(message); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, data := readFrame(t, conn)
+	var batch []json.RawMessage
+	if err := json.Unmarshal(data, &batch); err != nil || len(batch) != 3 || string(batch[2]) != string(message) {
+		t.Fatalf("batch %q", data)
+	}
+}
+
+func TestBatchingNeedsOptIn(t *testing.T) {
+	client, conn := dialEngine(t, "", WithBatching(BatchBytes, 10*time.Millisecond))
+	readFrame(t, conn) // greeting
+	for i := range 3 {
+		if err := client.Send([]byte(`{"n":` + strconv.Itoa(i) + `}`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := range 3 {
+		if _, data := readFrame(t, conn); string(data) != `{"n":`+strconv.Itoa(i)+`}` {
+			t.Fatalf("client without opt-in got %q", data)
+		}
+	}
+}
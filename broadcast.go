@@ -0,0 +1,76 @@
+package websocket
+
+import (
+	"github.com/gorilla/websocket"
+	"github.com/satori/go.uuid"
+	"sync"
+)
+
+// payload is a message fanned out to many clients. It is encoded at most once
+// per protocol and framed once per protocol as a websocket.PreparedMessage,
+// whatever the number of recipients.
+type payload struct {
+	command  string            // envelope command, empty for raw payloads
+	metadata map[string]string // envelope metadata, nil for raw payloads
+	encode   func(protocol int) []byte
+	once     [2]sync.Once
+	data     [2][]byte
+	prepared [2]*websocket.PreparedMessage
+	err      [2]error
+}
+
+// newRawPayload wraps bytes that are sent as is to every protocol.
+func newRawPayload(message []byte) *payload {
+	return &payload{encode: func(int) []byte {
+		return message
+	}}
+}
+
+// newEnvelopePayload wraps an envelope encoded as JSON for text clients and as proto for binary clients.
+// The metadata may be filled until the payload is first encoded.
+func newEnvelopePayload(command string, data []byte) *payload {
+	requestId := uuid.NewV4().String()
+	p := &payload{command: command, metadata: make(map[string]string)}
+	p.encode = func(protocol int) []byte {
+		if protocol == websocket.BinaryMessage {
+			return (&ProtoFuncWrapper{ProtoMessage: &ProtoMessage{
+				RequestId: requestId,
+				Command:   command,
+				Data:      data,
+				Metadata:  p.metadata,
+			}}).toBytes()
+		}
+		return (&JsonMessage{
+			RequestId: requestId,
+			Command:   command,
+			Data:      data,
+			Metadata:  p.metadata,
+		}).toBytes()
+	}
+	return p
+}
+
+// slot returns the cache index of protocol.
+func (p *payload) slot(protocol int) int {
+	if protocol == websocket.BinaryMessage {
+		return 1
+	}
+	return 0
+}
+
+// bytes returns the payload encoded for protocol.
+func (p *payload) bytes(protocol int) []byte {
+	i := p.slot(protocol)
+	p.once[i].Do(func() {
+		p.data[i] = p.encode(protocol)
+		p.prepared[i], p.err[i] = websocket.NewPreparedMessage(protocol, p.data[i])
+	})
+	return p.data[i]
+}
+
+// prepare returns the payload framed for protocol.
+func (p *payload) prepare(protocol int) (*websocket.PreparedMessage, error) {
+	p.bytes(protocol)
+	i := p.slot(protocol)
+	return p.prepared[i], p.err[i]
+}
@@ -0,0 +1,107 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"sync/atomic"
+	"testing"
+
+	ws "github.com/gorilla/websocket"
+	"google.golang.org/protobuf/proto"
+)
+
+// subscribers returns n JSON and n proto synthetic clients subscribed to channel.
+func subscribers(t *testing.T, e *Engine, channel string, n int) (clients []*Client) {
+	for i := range 2 * n {
+		protocol := ws.TextMessage
+		if i%2 == 1 {
+			protocol = ws.BinaryMessage
+		}
+		c := e.NewSyntheticClient(WithProtocol(protocol))
+		if err := e.Subscribe(c.ID(), channel); err != nil {
+			t.Fatal(err)
+		}
+		clients = append(clients, c)
+	}
+	return
+}
+
+func TestPublishMessageEncodesOncePerCodec(t *testing.T) {
+	e := newTestEngine()
+	defer e.Shutdown(context.Background())
+	clients := subscribers(t, e, "news", 8)
+
+	p := newEnvelopePayload("update", []byte(`{"n":1}`))
+	var encodes [2]atomic.Int32
+	encode := p.encode
+	p.encode = func(protocol int) []byte {
+		encodes[p.slot(protocol)].Add(1)
+		return encode(protocol)
+	}
+	if err := e.publish(context.Background(), "news", p); err != nil {
+		t.Fatal(err)
+	}
+	if text, binary := encodes[0].Load(), encodes[1].Load(); text != 1 || binary != 1 {
+		t.Fatalf("encoded %d times as JSON and %d times as proto", text, binary)
+	}
+
+	var prepared [2]*ws.PreparedMessage
+	for _, c := range clients {
+		f, ok := c.queue.pop()
+		if !ok || f.payload != p || f.priority != PriorityBroadcast {
+			t.Fatalf("client queued %+v", f)
+		}
+		message, err := f.payload.prepare(c.Protocol())
+		if err != nil || message == nil {
+			t.Fatalf("prepare: %v", err)
+		}
+		i := p.slot(c.Protocol())
+		if prepared[i] == nil {
+			prepared[i] = message
+		} else if prepared[i] != message {
+			t.Fatal("subscribers of a codec got different prepared messages")
+		}
+	}
+	if text, binary := encodes[0].Load(), encodes[1].Load(); text != 1 || binary != 1 {
+		t.Fatalf("preparing encoded %d times as JSON and %d times as proto", text, binary)
+	}
+}
+
+func TestPublishMessageSharesEncodings(t *testing.T) {
+	e := newTestEngine()
+	defer e.Shutdown(context.Background())
+	clients := subscribers(t, e, "news", 4)
+
+	if err := e.PublishMessage("news", "update", []byte(`{"n":1}`)); err != nil {
+		t.Fatal(err)
+	}
+	var (
+		data      [2][]byte
+		requestID string
+	)
+	for _, c := range clients {
+		f, ok := c.queue.pop()
+		if !ok || f.payload == nil {
+			t.Fatalf("client queued %+v", f)
+		}
+		i := f.payload.slot(c.Protocol())
+		if data[i] == nil {
+			data[i] = f.data
+		} else if &data[i][0] != &f.data[0] {
+			t.Fatal("subscribers of a codec got separate encodings")
+		}
+	}
+
+	var message JsonMessage
+	if err := json.Unmarshal(data[0], &message); err != nil || message.Command != "update" || message.Metadata[MetadataChannel] != "news" {
+		t.Fatalf("JSON envelope %+v: %v", message, err)
+	}
+	requestID = message.RequestId
+	var protoMessage ProtoMessage
+	if err := proto.Unmarshal(data[1], &protoMessage); err != nil || protoMessage.Command != "update" || string(protoMessage.Data) != `{"n":1}` {
+		t.Fatalf("proto envelope %+v: %v", &protoMessage, err)
+	}
+	if protoMessage.RequestId != requestID {
+		t.Fatalf("envelopes carry request ids %s and %s", requestID, protoMessage.RequestId)
+	}
+}
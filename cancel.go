@@ -0,0 +1,76 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+)
+
+const (
+	CancelCommand   = "cancel" // command cancelling the in-flight request whose id is in Data
+	StatusCancelled = 499      // reply code of a cancelled request
+)
+
+var (
+	ErrRequestCancelled = errors.New("request cancelled")
+	ErrRequestNotFound  = errors.New("request not found")
+)
+
+// request is an in-flight request of a client.
+type request struct {
+	cancel    context.CancelCauseFunc
+	cancelled atomic.Bool
+}
+
+// track makes the request cancellable by id until done is called. It runs on
+// the read loop, so a cancel read next finds an async request.
+func (c *Client) track(ctx context.Context, requestID string) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	r := &request{cancel: cancel}
+	c.requests.Store(requestID, r)
+	return ctx, func() {
+		c.requests.CompareAndDelete(requestID, r)
+		cancel(nil)
+	}
+}
+
+// Cancel cancels the context of the in-flight request of the client with
+// requestID, it reports whether there was one not cancelled yet. The request
+// replies with StatusCancelled.
+func (c *Client) Cancel(requestID string) bool {
+	value, ok := c.requests.Load(requestID)
+	if !ok {
+		return false
+	}
+	r := value.(*request)
+	if !r.cancelled.CompareAndSwap(false, true) {
+		return false
+	}
+	r.cancel(ErrRequestCancelled)
+	return true
+}
+
+// RegisterCancelRouter registers CancelCommand for both codecs. Only requests
+// running off the read loop can be cancelled while they run, register the
+// commands to cancel WithAsync.
+func (e *Engine) RegisterCancelRouter(opts ...RouteOption) {
+	e.RegisterJsonContextRouter(CancelCommand, func(ctx context.Context, message *JsonMessage) {
+		message.Code, message.Message = cancelCommand(ctx, message.Data)
+	}, opts...)
+	e.RegisterProtoContextRouter(CancelCommand, func(ctx context.Context, message *ProtoMessage) {
+		message.Code, message.Message = cancelCommand(ctx, message.Data)
+	}, opts...)
+}
+
+// cancelCommand cancels the request of the connection of ctx with the id in data.
+func cancelCommand(ctx context.Context, data []byte) (int32, string) {
+	client := ClientFromContext(ctx)
+	if client == nil || len(data) == 0 {
+		return http.StatusBadRequest, "request id is required"
+	}
+	if !client.Cancel(string(data)) {
+		return http.StatusNotFound, ErrRequestNotFound.Error()
+	}
+	return http.StatusOK, Success
+}
@@ -0,0 +1,139 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	ws "github.com/gorilla/websocket"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestCancelRequest(t *testing.T) {
+	e := newTestEngine()
+	defer e.Shutdown(t.Context())
+	e.RegisterCancelRouter()
+	search := func(ctx context.Context) int32 {
+		<-ctx.Done()
+		return http.StatusOK
+	}
+	e.RegisterJsonContextRouter("search", func(ctx context.Context, m *JsonMessage) {
+		m.Code = search(ctx)
+	}, WithAsync())
+	e.RegisterProtoContextRouter("search", func(ctx context.Context, m *ProtoMessage) {
+		m.Code = search(ctx)
+	}, WithAsync())
+
+	for _, protocol := range []int{ws.TextMessage, ws.BinaryMessage} {
+		c := e.NewSyntheticClient(WithProtocol(protocol))
+		send := func(requestID, command, data string) {
+			message := &ProtoMessage{RequestId: requestID, SocketId: c.ID(), Command: command, Data: []byte(data)}
+			if protocol == ws.TextMessage {
+				raw, _ := json.Marshal(&JsonMessage{RequestId: requestID, SocketId: c.ID(), Command: command, Data: []byte(data)})
+				c.Dispatch(protocol, raw)
+				return
+			}
+			raw, _ := proto.Marshal(message)
+			c.Dispatch(protocol, raw)
+		}
+		codes := make(map[string]int32)
+		receive := func(n int) {
+			deadline := time.Now().Add(time.Second)
+			for len(codes) < n && time.Now().Before(deadline) {
+				for _, frame := range c.Drain() {
+					var reply ProtoMessage
+					if protocol == ws.TextMessage {
+						var text JsonMessage
+						_ = json.Unmarshal(frame, &text)
+						reply.RequestId, reply.Code = text.RequestId, text.Code
+					} else {
+						_ = proto.Unmarshal(frame, &reply)
+					}
+					codes[reply.RequestId] = reply.Code
+				}
+				time.Sleep(time.Millisecond)
+			}
+		}
+
+		send("1", "search", "go") // async, the read loop goes on
+		send("2", CancelCommand, "1")
+		send("3", CancelCommand, "1") // already cancelled
+		receive(3)
+		if codes["1"] != StatusCancelled || codes["2"] != http.StatusOK || codes["3"] != http.StatusNotFound {
+			t.Fatalf("protocol %d: replies %v", protocol, codes)
+		}
+		if c.Cancel("1") {
+			t.Fatal("a finished request is still cancellable")
+		}
+	}
+}
+
+func TestAsyncLimitAndShutdown(t *testing.T) {
+	e := newTestEngine()
+	release := make(chan struct{})
+	e.RegisterJsonRouter("wait", func(m *JsonMessage) {
+		<-release // ignores the client context, like a stuck downstream call
+		m.Code = http.StatusOK
+	}, WithAsync())
+
+	c := e.NewSyntheticClient(WithMaxAsync(2))
+	for i := range 3 {
+		raw, _ := json.Marshal(&JsonMessage{RequestId: strconv.Itoa(i), SocketId: c.ID(), Command: "wait"})
+		c.Dispatch(ws.TextMessage, raw)
+	}
+	var reply JsonMessage
+	if replies := c.Drain(); len(replies) != 1 || json.Unmarshal(replies[0], &reply) != nil ||
+		reply.Code != http.StatusTooManyRequests || reply.RequestId != "2" {
+		t.Fatalf("request over the async limit replied %q", replies)
+	}
+
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- e.Shutdown(context.Background())
+	}()
+	select {
+	case err := <-shutdown:
+		t.Fatalf("shutdown returned %v with async handlers running", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	select {
+	case err := <-shutdown:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown did not return once the handlers finished")
+	}
+}
+
+func TestShutdownDeadlineAsyncHandler(t *testing.T) {
+	e := newTestEngine()
+	release := make(chan struct{})
+	defer close(release)
+	e.RegisterJsonRouter("wait", func(m *JsonMessage) {
+		<-release
+	}, WithAsync())
+	c := e.NewSyntheticClient()
+	raw, _ := json.Marshal(&JsonMessage{RequestId: "1", SocketId: c.ID(), Command: "wait"})
+	c.Dispatch(ws.TextMessage, raw)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- e.Shutdown(ctx)
+	}()
+	select {
+	case err := <-shutdown:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("shutdown returned %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("shutdown waited for an async handler past its deadline")
+	}
+}
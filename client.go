@@ -1,20 +1,28 @@
 package websocket
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/gorilla/websocket"
 	"github.com/satori/go.uuid"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/protobuf/proto"
+	"log/slog"
+	"net"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const (
 	SendLimit = 100
-	BreakTime = 600  // heartbeat breakTime in seconds
-	Interval  = 1000 // heartbeat interval in milliseconds
+	BreakTime = 600              // heartbeat breakTime in seconds
+	Interval  = 1000             // heartbeat interval in milliseconds
+	WriteWait = 10 * time.Second // time allowed to write a control frame
+	MaxAsync  = 64               // default number of async handlers a client may run at once
 
 	Connected = "connected"
 	Success   = "success"
@@ -25,53 +33,147 @@ type Client struct {
 	once   *sync.Once
 	engine *Engine
 
-	id        string          // unique identifier for each connection
-	socket    *websocket.Conn // user connection
-	protocol  int
-	message   chan []byte
-	sendClose bool          // send channel is close
-	close     chan struct{} // close channel
-	firstTime int64         // first connection time
-	lastTime  int64         // last heartbeat time
-	breakTime int64         // heartbeat breakTime
-	interval  int64         // heartbeat interval
-	values    map[any]any   // context values
-	errs      chan error
+	id            string          // unique identifier for each connection
+	socket        *websocket.Conn // user connection
+	log           *slog.Logger    // engine logger carrying the connection fields
+	ip            string          // client address the connection slot is reserved for
+	endpoint      *endpoint       // upgrade route settings, nil for synthetic clients
+	protocol      atomic.Int32
+	queue         *outQueue
+	batch         *batcher      // nil unless the client opted in to batching
+	close         chan struct{} // close channel
+	closing       chan struct{} // graceful close signal for the write loop
+	closeOnce     *sync.Once
+	closeMsg      []byte // close frame sent by the write loop on graceful close
+	reason        DisconnectReason
+	reasonMux     *sync.Mutex
+	firstTime     int64                   // first connection time
+	lastTime      atomic.Int64            // last heartbeat time
+	breakTime     int64                   // heartbeat breakTime
+	interval      int64                   // heartbeat interval
+	pingTime      int64                   // ping interval in milliseconds, 0 disables server pings
+	ping          chan struct{}           // ping signal for the write loop
+	user          atomic.Value            // authenticated user id
+	rateLimit     *tokenBucket            // connection rate limit
+	commandLimits map[string]*tokenBucket // per-command rate limits, only used by the read loop
+	values        sync.Map                // context values
+	requests      sync.Map                // in-flight request id -> *request
+	async         atomic.Int32            // async handlers running
+	maxAsync      int32                   // async handler limit, 0 means unlimited
+	ctx           context.Context         // cancelled with the disconnect reason on release
+	cancel        context.CancelCauseFunc
+
+	heartbeatTimer atomic.Pointer[timerTask]
+	pingTimer      atomic.Pointer[timerTask]
 }
 
 func newDefaultClient(conn *websocket.Conn) *Client {
 	times := time.Now().Unix()
-	return &Client{
+	client := &Client{
 		once: &sync.Once{},
 
-		id:        uuid.NewV4().String(),
-		socket:    conn,
-		protocol:  websocket.TextMessage,
-		message:   make(chan []byte, SendLimit),
-		sendClose: false,
-		close:     make(chan struct{}, 1),
-		firstTime: times,
-		lastTime:  times,
-		breakTime: BreakTime,
-		interval:  Interval,
-		values:    make(map[any]any),
-		errs:      make(chan error, SendLimit),
+		id:            uuid.NewV4().String(),
+		socket:        conn,
+		queue:         newOutQueue(SendLimit),
+		close:         make(chan struct{}),
+		closing:       make(chan struct{}),
+		closeOnce:     &sync.Once{},
+		reasonMux:     &sync.Mutex{},
+		firstTime:     times,
+		breakTime:     BreakTime,
+		interval:      Interval,
+		ping:          make(chan struct{}, 1),
+		rateLimit:     newTokenBucket(&limitConfig{}),
+		commandLimits: make(map[string]*tokenBucket),
+		maxAsync:      MaxAsync,
 	}
+	client.ctx, client.cancel = context.WithCancelCause(context.Background())
+	client.protocol.Store(websocket.TextMessage)
+	client.lastTime.Store(times)
+	return client
 }
 
 func (c *Client) execute(message []byte) {
-	switch c.protocol {
+	defer c.recoverHandler()
+
+	switch c.Protocol() {
 	case websocket.TextMessage:
 		c.handleTextMessage(message)
 	case websocket.BinaryMessage:
 		c.handleProtoMessage(message)
 	default:
-		c.engine.log.ErrorString("Client", "execute error", "unsupported protocol")
+		c.log.Error("unsupported protocol")
+	}
+}
+
+// recoverHandler reports a handler panic, it must be deferred.
+func (c *Client) recoverHandler() {
+	if r := recover(); r != nil {
+		err := fmt.Errorf("handler panic: %v", r)
+		c.log.Error("handler panic", "err", err)
+		c.engine.hooks.error(c, err, http.StatusInternalServerError)
+	}
+}
+
+// ErrTooManyAsync is replied with 429 to an async request over the in-flight limit of its client.
+var ErrTooManyAsync = errors.New("too many requests in flight")
+
+// run serves a request whose span has started, on the read loop unless the
+// command is async: then it gets its own goroutine and the read loop goes on,
+// so later messages, e.g. a cancel, are read while it runs. An async request
+// over the client limit is answered with 429, and with 503 once the engine is
+// shutting down.
+func (c *Client) run(ctx context.Context, requestID string, route *routeConfig, span trace.Span, response ErrorResponder, serve func(ctx context.Context)) {
+	if route == nil || !route.async {
+		defer finishSpan(span)
+		ctx, done := c.track(ctx, requestID)
+		defer done()
+		serve(ctx)
+		return
+	}
+	if code, err := c.startAsync(); err != nil {
+		spanError(span, err)
+		finishSpan(span)
+		c.handleError(response, err, code)
+		return
+	}
+	ctx, done := c.track(ctx, requestID)
+	go func() {
+		defer c.endAsync()
+		defer c.recoverHandler()
+		defer finishSpan(span)
+		defer done()
+		serve(ctx)
+	}()
+}
+
+// startAsync takes an async handler slot of the client and accounts for its
+// goroutine, so that Shutdown waits for it until its deadline.
+func (c *Client) startAsync() (int32, error) {
+	if n := c.async.Add(1); c.maxAsync > 0 && n > c.maxAsync {
+		c.async.Add(-1)
+		return http.StatusTooManyRequests, ErrTooManyAsync
 	}
+	if !c.engine.track(1) {
+		c.async.Add(-1)
+		return http.StatusServiceUnavailable, ErrEngineClosed
+	}
+	return 0, nil
+}
+
+// endAsync releases the slot taken by startAsync.
+func (c *Client) endAsync() {
+	c.async.Add(-1)
+	c.engine.routines.Done()
 }
 
 func (c *Client) handleTextMessage(message []byte) {
 	var textMessage JsonMessage
+	if err := checkJSONDepth(message, c.engine.maxJSONDepth); err != nil {
+		c.engine.stats.invalid.Add(1)
+		c.handleError(&textMessage, err, http.StatusBadRequest)
+		return
+	}
 	if err := json.Unmarshal(message, &textMessage); err != nil {
 		c.handleError(&textMessage, err, http.StatusBadRequest)
 		return
@@ -80,18 +182,56 @@ func (c *Client) handleTextMessage(message []byte) {
 		c.handleError(&textMessage, err, http.StatusBadRequest)
 		return
 	}
-	handler, err := c.engine.jsonRouter.get(textMessage.Command)
+	if !c.endpoint.allows(textMessage.Command) {
+		c.handleError(&textMessage, ErrCommandNotAllowed, http.StatusForbidden)
+		return
+	}
+	handler, route, err := c.engine.jsonRouter.get(textMessage.Command)
 	if err != nil {
+		c.engine.logSampled(c.log, slog.LevelWarn, "unknown command", "command", textMessage.Command, "request_id", textMessage.RequestId)
 		c.handleError(&textMessage, err, http.StatusBadRequest)
 		return
 	}
-	handler(&textMessage)
-	c.send(textMessage.toBytes())
+	ctx, span := c.startSpan(textMessage.Command, textMessage.RequestId, textMessage.Metadata)
+	if err = c.checkSize(message, textMessage.Data, route); err != nil {
+		spanError(span, err)
+		span.End()
+		return
+	}
+	if action, ok := c.checkRateLimit(textMessage.Command, route); !ok {
+		spanError(span, ErrRateLimited)
+		span.End()
+		c.rateLimited(&textMessage, action)
+		return
+	}
+	c.engine.metrics.MessageReceived(textMessage.Command, codec(websocket.TextMessage))
+	c.run(ctx, textMessage.RequestId, route, span, &textMessage, func(ctx context.Context) {
+		start := time.Now()
+		err := c.invoke(ctx, route, textMessage.Command, func(ctx context.Context) {
+			handler(ctx, &textMessage)
+		})
+		duration := time.Since(start)
+		c.engine.metrics.HandlerDuration(textMessage.Command, codec(websocket.TextMessage), duration)
+		if err != nil { // the handler may still write textMessage, reply from a copy
+			spanError(span, err)
+			c.abandoned(route, &JsonMessage{RequestId: textMessage.RequestId, SocketId: textMessage.SocketId, Command: textMessage.Command}, textMessage.Command, err)
+			return
+		}
+		c.engine.logSampled(c.log, slog.LevelDebug, "message handled", "command", textMessage.Command, "request_id", textMessage.RequestId,
+			"size", len(message), "code", textMessage.Code, "duration", duration)
+		spanReply(span, textMessage.Code, textMessage.Message)
+		c.reply(route, textMessage.Command, textMessage.toBytes())
+	})
 }
 
 func (c *Client) handleProtoMessage(message []byte) {
 	var protoMessage ProtoMessage
 	wrapper := &ProtoFuncWrapper{ProtoMessage: &protoMessage}
+	if err := checkProtoFields(message, c.engine.maxProtoFields); err != nil {
+		c.engine.stats.invalid.Add(1)
+		c.handleError(wrapper, err, http.StatusBadRequest)
+		return
+	}
 	if err := proto.Unmarshal(message, &protoMessage); err != nil {
 		c.handleError(wrapper, err, http.StatusBadRequest)
 		return
@@ -102,60 +242,100 @@ func (c *Client) handleProtoMessage(message []byte) {
 		return
 	}
 
-	handler, err := c.engine.protoRouter.get(protoMessage.Command)
+	if !c.endpoint.allows(protoMessage.Command) {
+		c.handleError(wrapper, ErrCommandNotAllowed, http.StatusForbidden)
+		return
+	}
+	handler, route, err := c.engine.protoRouter.get(protoMessage.Command)
 	if err != nil {
+		c.engine.logSampled(c.log, slog.LevelWarn, "unknown command", "command", protoMessage.Command, "request_id", protoMessage.RequestId)
 		c.handleError(wrapper, err, http.StatusBadRequest)
 		return
 	}
-	handler(&protoMessage)
-	c.send(wrapper.toBytes())
+	ctx, span := c.startSpan(protoMessage.Command, protoMessage.RequestId, protoMessage.Metadata)
+	if err = c.checkSize(message, protoMessage.Data, route); err != nil {
+		spanError(span, err)
+		span.End()
+		return
+	}
+	if action, ok := c.checkRateLimit(protoMessage.Command, route); !ok {
+		spanError(span, ErrRateLimited)
+		span.End()
+		c.rateLimited(wrapper, action)
+		return
+	}
+	c.engine.metrics.MessageReceived(protoMessage.Command, codec(websocket.BinaryMessage))
+	c.run(ctx, protoMessage.RequestId, route, span, wrapper, func(ctx context.Context) {
+		start := time.Now()
+		err := c.invoke(ctx, route, protoMessage.Command, func(ctx context.Context) {
+			handler(ctx, &protoMessage)
+		})
+		duration := time.Since(start)
+		c.engine.metrics.HandlerDuration(protoMessage.Command, codec(websocket.BinaryMessage), duration)
+		if err != nil { // the handler may still write protoMessage, reply from a copy
+			spanError(span, err)
+			c.abandoned(route, &ProtoFuncWrapper{ProtoMessage: &ProtoMessage{RequestId: protoMessage.RequestId,
+				SocketId: protoMessage.SocketId, Command: protoMessage.Command}}, protoMessage.Command, err)
+			return
+		}
+		c.engine.logSampled(c.log, slog.LevelDebug, "message handled", "command", protoMessage.Command, "request_id", protoMessage.RequestId,
+			"size", len(message), "code", protoMessage.Code, "duration", duration)
+		spanReply(span, protoMessage.Code, protoMessage.Message)
+		c.reply(route, protoMessage.Command, wrapper.toBytes())
+	})
 }
 
 func (c *Client) handleError(response ErrorResponder, err error, code int32) {
+	c.engine.hooks.error(c, err, code)
 	response.SetError(err, code)
-	c.send(response.toBytes())
+	c.send("", response.toBytes())
 }
 
 // read message
 func (c *Client) read() {
+	defer c.engine.routines.Done()
 	defer func() {
 		if err := recover(); err != nil {
-			c.engine.log.ErrorString("Client", "read error", err.(error).Error())
+			c.log.Error("read loop panic", "panic", err)
 		}
 	}()
 
-	var closeErr *websocket.CloseError
 	for {
-		select {
-		case <-c.close:
+		types, message, err := c.socket.ReadMessage()
+		if errors.Is(err, websocket.ErrReadLimit) {
+			c.engine.stats.oversized.Add(1)
+		}
+		if err != nil {
+			c.releaseWith(readFailureReason(err))
 			return
-		default:
-			types, message, err := c.socket.ReadMessage()
-			if err != nil && errors.As(err, &closeErr) {
-				return
-			}
+		}
 
-			if message != nil {
-				c.setLastTime(time.Now().Unix()) // set last time
-			}
+		c.receive(types, message)
+	}
+}
 
-			switch types {
-			case websocket.TextMessage, websocket.BinaryMessage:
-				c.protocol = types
-				c.execute(message)
-			case -1: // No ping frames were detected
-				c.release()
-				return
-			}
-		}
+// receive handles a message read from the connection.
+func (c *Client) receive(types int, message []byte) {
+	c.setLastTime(c.engine.clock.Now().Unix()) // set last time
+	if !c.endpoint.allowsProtocol(types) {
+		c.closeWith(DisconnectReason{Kind: DisconnectProtocolError, Code: websocket.CloseUnsupportedData, Text: "codec not allowed"})
+		return
+	}
+	switch types {
+	case websocket.TextMessage, websocket.BinaryMessage:
+		c.engine.metrics.BytesReceived(codec(types), len(message))
+		c.engine.hooks.message(c, types, message)
+		c.protocol.Store(int32(types))
+		c.execute(message)
 	}
 }
 
 // write Send message
 func (c *Client) write() {
+	defer c.engine.routines.Done()
 	defer func() {
 		if err := recover(); err != nil {
-			c.engine.log.ErrorString("Client", "write error", err.(error).Error())
+			c.log.Error("write loop panic", "panic", err)
 		}
 	}()
 
@@ -163,88 +343,313 @@ func (c *Client) write() {
 		select {
 		case <-c.close: // Listen for close signal
 			return
-		case v := <-c.message:
-			var closeErr *websocket.CloseError
-			if err := c.socket.WriteMessage(c.protocol, v); err != nil && errors.As(err, &closeErr) {
+		case <-c.queue.notify:
+			if err := c.flush(); err != nil {
+				c.releaseWith(writeFailureReason(err))
 				return
 			}
+		case <-c.batch.expired():
+			if err := c.writeBatch(); err != nil {
+				c.releaseWith(writeFailureReason(err))
+				return
+			}
+		case <-c.ping:
+			if err := c.socket.WriteControl(websocket.PingMessage, nil, time.Now().Add(WriteWait)); err != nil {
+				c.releaseWith(writeFailureReason(err))
+				return
+			}
+		case <-c.closing:
+			if c.flush() == nil {
+				_ = c.writeBatch()
+			}
+			_ = c.socket.WriteControl(websocket.CloseMessage, c.closeMsg, time.Now().Add(WriteWait))
+			c.release()
+			return
 		}
 	}
 }
 
-// send message
-func (c *Client) send(message []byte) {
-	select {
-	case <-c.close:
-		return
-	default:
-		if !c.sendClose {
-			c.message <- message
-		}
+// writeMessage writes a data frame using the client protocol.
+func (c *Client) writeMessage(message []byte) error {
+	protocol := c.Protocol()
+	if err := c.socket.WriteMessage(protocol, message); err != nil {
+		return err
 	}
+	c.engine.hooks.send(c, protocol, message)
+	return nil
 }
 
-// release
-func (c *Client) release() {
-	c.once.Do(func() {
-		c.close <- struct{}{}
+// writeFrame writes a queued frame, shared payloads are written as prepared messages.
+func (c *Client) writeFrame(f frame) error {
+	if f.payload == nil {
+		return c.writeMessage(f.data)
+	}
+	protocol := c.Protocol()
+	prepared, err := f.payload.prepare(protocol)
+	if err != nil {
+		return err
+	}
+	if err = c.socket.WritePreparedMessage(prepared); err != nil {
+		return err
+	}
+	c.engine.hooks.send(c, protocol, f.payload.bytes(protocol))
+	return nil
+}
 
-		select {
-		case <-c.message:
-		default:
-			c.sendClose = true
-			close(c.message)
+// flush writes the queued messages without waiting for new ones. With
+// batching they are collected and written once the batch is large enough,
+// right away when there is no batch delay, otherwise when the delay expires.
+func (c *Client) flush() error {
+	for {
+		f, ok := c.queue.pop()
+		if !ok {
+			break
 		}
+		if c.batch == nil {
+			if err := c.writeFrame(f); err != nil {
+				return err
+			}
+			c.sent(f)
+			continue
+		}
+		c.sent(f)
+		if c.batch.add(f.data) {
+			if err := c.writeBatch(); err != nil {
+				return err
+			}
+		}
+	}
+	if c.batch != nil && c.batch.maxDelay == 0 {
+		return c.writeBatch()
+	}
+	return nil
+}
 
-		select {
-		case <-c.close:
-		default:
-			close(c.close)
+// sent records a message handed to the socket or the batch.
+func (c *Client) sent(f frame) {
+	name := codec(c.Protocol())
+	c.engine.metrics.MessageSent(f.command, name)
+	c.engine.metrics.BytesSent(name, len(f.data))
+}
+
+// writeBatch writes the pending batch as a single frame.
+func (c *Client) writeBatch() error {
+	if c.batch == nil {
+		return nil
+	}
+	protocol := c.Protocol()
+	messages, err := c.batch.writeTo(c.socket, protocol)
+	if err != nil {
+		return err
+	}
+	for _, message := range messages {
+		c.engine.hooks.send(c, protocol, message)
+	}
+	return nil
+}
+
+// closeWith records a server initiated reason and asks the write loop to flush
+// the queued messages, send a close frame with the reason code and text, then
+// release the client. The client is released anyway after WriteWait.
+func (c *Client) closeWith(reason DisconnectReason) {
+	c.setReason(reason)
+	c.closeOnce.Do(func() {
+		c.closeMsg = websocket.FormatCloseMessage(reason.Code, reason.Text)
+		close(c.closing)
+		if c.socket == nil { // a synthetic client has no write loop to flush
+			c.release()
+			return
 		}
-		close(c.errs)
+		c.engine.wheel.afterFunc(WriteWait, func() {
+			go c.release()
+		})
+	})
+}
+
+// releaseWith records reason and releases the client at once.
+func (c *Client) releaseWith(reason DisconnectReason) {
+	c.setReason(reason)
+	c.release()
+}
+
+// setReason records why the client went away, the first reason wins.
+func (c *Client) setReason(reason DisconnectReason) {
+	c.reasonMux.Lock()
+	defer c.reasonMux.Unlock()
+	if c.reason.Kind == DisconnectUnknown {
+		c.reason = reason
+	}
+}
+
+// DisconnectReason returns why the client went away, its Kind is DisconnectUnknown while connected.
+func (c *Client) DisconnectReason() DisconnectReason {
+	c.reasonMux.Lock()
+	defer c.reasonMux.Unlock()
+	return c.reason
+}
 
-		_ = c.socket.Close()
+// send queues the reply to command
+func (c *Client) send(command string, message []byte) {
+	_ = c.enqueue(frame{data: message, command: command, priority: PriorityReply})
+}
+
+// reply queues the reply to a request of route, the final reply of a stream
+// is kept like its chunks.
+func (c *Client) reply(route *routeConfig, command string, message []byte) {
+	_ = c.enqueue(frame{data: message, command: command, priority: PriorityReply, keep: route != nil && route.stream})
+}
+
+// Send queues a message for the client, as a reply unless WithPriority says
+// otherwise. When the queue is full the client backpressure policy applies:
+// the message may wait, be dropped or replace a queued one, or the client may
+// be disconnected as a slow consumer.
+func (c *Client) Send(message []byte, opts ...SendOption) error {
+	return c.enqueue(frame{data: message, priority: PriorityReply}, opts...)
+}
+
+// sendPayload queues a payload shared with other clients, encoded for the client protocol.
+func (c *Client) sendPayload(p *payload, opts ...SendOption) error {
+	return c.enqueue(frame{data: p.bytes(c.Protocol()), payload: p, command: p.command, priority: PriorityReply}, opts...)
+}
+
+// enqueue pushes f to the outbound queue according to the backpressure policy.
+func (c *Client) enqueue(f frame, opts ...SendOption) error {
+	if c.isClosed() {
+		return ErrClientClosed
+	}
+	for _, opt := range opts {
+		opt.apply(&f)
+	}
+
+	dropped, err := c.queue.push(f, c.close)
+	if dropped > 0 {
+		c.engine.stats.dropped.Add(uint64(dropped))
+		c.engine.metrics.MessagesDropped(dropped)
+	}
+	if errors.Is(err, ErrSlowConsumer) {
+		length, bytes, _ := c.queue.stats()
+		c.log.Warn("slow consumer disconnected", "queued", length, "queued_bytes", bytes)
+		c.engine.stats.slowConsumers.Add(1)
+		c.closeWith(DisconnectReason{Kind: DisconnectSlowConsumer, Code: websocket.ClosePolicyViolation, Text: "slow consumer", Err: err})
+	}
+	return err
+}
+
+// QueueStats returns the outbound queue depth in messages and bytes and how many messages were dropped.
+func (c *Client) QueueStats() (length, bytes int, dropped uint64) {
+	return c.queue.stats()
+}
+
+// release
+func (c *Client) release() {
+	c.once.Do(func() {
+		close(c.close)
+		c.cancel(c.DisconnectReason())
+		c.heartbeatTimer.Load().stop()
+		c.pingTimer.Load().stop()
+		c.queue.clear()
+
+		if c.socket != nil { // synthetic clients hold no connection nor slot
+			_ = c.socket.Close()
+			c.engine.unreserve(c.ip)
+			c.engine.metrics.ConnectionClosed(c.DisconnectReason().Kind)
+		}
 		if c.engine.storage != nil {
 			c.engine.delete(c.id)
 		}
+		if userID := c.engine.users.unbind(c); userID != "" {
+			c.engine.expireUserBucket(userID)
+		}
+		reason := c.DisconnectReason()
+		c.log.Info("disconnected", "reason", reason.Kind.String(), "code", reason.Code, "text", reason.Text,
+			"duration", c.engine.clock.Now().Sub(c.ConnectedAt()))
+		c.engine.hooks.disconnect(c, c.DisconnectReason())
 	})
 }
 
+// resetTime restarts the connection and heartbeat times on the engine clock.
+func (c *Client) resetTime() {
+	now := c.engine.clock.Now().Unix()
+	c.firstTime = now
+	c.lastTime.Store(now)
+}
+
 // setLastTime Set the last time
 func (c *Client) setLastTime(currentTime int64) {
-	c.lastTime = currentTime
+	c.lastTime.Store(currentTime)
 }
 
 // isTimeout or not
 func (c *Client) isTimeout(currentTime int64) bool {
-	return c.lastTime+c.breakTime <= currentTime
+	return c.lastTime.Load()+c.breakTime <= currentTime
+}
+
+// isClosed reports whether the client has been released.
+func (c *Client) isClosed() bool {
+	select {
+	case <-c.close:
+		return true
+	default:
+		return false
+	}
+}
+
+// scheduleHeartbeat arms the idle check on the engine timing wheel. The check
+// fires when the client would time out, but never more often than interval.
+func (c *Client) scheduleHeartbeat() {
+	delay := time.Duration(c.lastTime.Load()+c.breakTime-c.engine.clock.Now().Unix()) * time.Second
+	if interval := time.Duration(c.interval) * time.Millisecond; delay < interval {
+		delay = interval
+	}
+	c.heartbeatTimer.Store(c.engine.wheel.afterFunc(delay, c.heartbeat))
+	if c.isClosed() {
+		c.heartbeatTimer.Load().stop()
+	}
 }
 
 // heartbeat detection
 func (c *Client) heartbeat() {
-	ticker := time.NewTicker(time.Millisecond * time.Duration(c.interval))
-	defer ticker.Stop()
+	if c.isClosed() {
+		return
+	}
+	if c.isTimeout(c.engine.clock.Now().Unix()) {
+		c.engine.metrics.HeartbeatTimeout()
+		c.closeWith(DisconnectReason{Kind: DisconnectIdleTimeout, Code: websocket.CloseNormalClosure, Text: "idle timeout"})
+		return
+	}
+	c.scheduleHeartbeat()
+}
 
-	for {
-		select {
-		case <-ticker.C:
-			if c.isTimeout(time.Now().Unix()) {
-				c.release()
-				return
-			}
-		case <-c.close:
-			return
-		}
+// schedulePing arms the next server ping on the engine timing wheel.
+func (c *Client) schedulePing() {
+	if c.pingTime <= 0 {
+		return
+	}
+	c.pingTimer.Store(c.engine.wheel.afterFunc(time.Duration(c.pingTime)*time.Millisecond, c.sendPing))
+	if c.isClosed() {
+		c.pingTimer.Load().stop()
 	}
 }
 
+// sendPing asks the write loop to send a ping frame.
+func (c *Client) sendPing() {
+	if c.isClosed() {
+		return
+	}
+	select {
+	case c.ping <- struct{}{}:
+	default:
+	}
+	c.schedulePing()
+}
+
 func (c *Client) firstMessage() {
-	msg := buildConnectedResponse(c.protocol, c.id)
+	msg := buildConnectedResponse(c.Protocol(), c.id)
 	if msg == nil {
-		c.engine.log.ErrorString("Client", "firstMessage error", "unsupported protocol")
+		c.log.Error("unsupported protocol")
 		return
 	}
-	c.send(msg.toBytes())
+	_ = c.Send(msg.toBytes(), WithPriority(PriorityControl))
 }
 
 // buildConnectedResponse builds the "connected" success response for the given protocol and client id; used by firstMessage and tests.
@@ -269,31 +674,70 @@ func buildConnectedResponse(protocol int, id string) ErrorResponder {
 	}
 }
 
-// Deadline SetDeadline Set the deadline
+// ID returns the unique identifier of the connection.
+func (c *Client) ID() string {
+	return c.id
+}
+
+// Protocol returns the message type of the connection, websocket.TextMessage or websocket.BinaryMessage.
+func (c *Client) Protocol() int {
+	return int(c.protocol.Load())
+}
+
+// RemoteAddr returns the remote network address of the connection.
+func (c *Client) RemoteAddr() net.Addr {
+	if c.socket == nil {
+		return syntheticAddr{}
+	}
+	return c.socket.RemoteAddr()
+}
+
+// ConnectedAt returns when the connection was established.
+func (c *Client) ConnectedAt() time.Time {
+	return time.Unix(c.firstTime, 0)
+}
+
+// clientKey is the context key of the connection a message came from.
+type clientKey struct{}
+
+// ClientFromContext returns the connection a handler context belongs to, nil
+// outside of a handler. A Client is itself a context.Context, handlers may
+// derive their own contexts from it, e.g. with a deadline.
+func ClientFromContext(ctx context.Context) *Client {
+	client, _ := ctx.Value(clientKey{}).(*Client)
+	return client
+}
+
+// Deadline reports no deadline, a connection lasts until it is released.
 func (c *Client) Deadline() (deadline time.Time, ok bool) {
-	return time.Time{}, false
+	return c.ctx.Deadline()
 }
 
-// Done returns a channel that is closed when the context is done.
+// Done returns a channel that is closed when the client is released. Handler
+// contexts derive from the client, so they are done too.
 func (c *Client) Done() <-chan struct{} {
-	return c.close
+	return c.ctx.Done()
 }
 
-// Err returns a non-nil error value after the context is done.
+// Err returns context.Canceled once the client is released, nil before.
+// context.Cause(client) returns the DisconnectReason.
 func (c *Client) Err() error {
-	return <-c.errs
+	return c.ctx.Err()
 }
 
-// Value returns the value associated with key in the context, if any.
+// Value returns the value associated with key by SetValue or WithClientValues,
+// the client itself for the key of ClientFromContext.
 func (c *Client) Value(key any) any {
-	value, ok := c.values[key]
-	if !ok {
-		return nil
+	if key == (clientKey{}) {
+		return c
+	}
+	if value, ok := c.values.Load(key); ok {
+		return value
 	}
-	return value
+	return c.ctx.Value(key)
 }
 
-// SetValue sets the value associated with key in the context.
+// SetValue associates value with key, it is safe for concurrent use.
 func (c *Client) SetValue(key, value any) {
-	c.values[key] = value
+	c.values.Store(key, value)
 }
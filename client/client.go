@@ -0,0 +1,567 @@
+// Package client is a Go client of the websocket engine: it dials, learns its
+// socket id from the connected greeting, correlates calls with their replies
+// by request id, dispatches published messages to channel handlers and
+// reconnects with exponential backoff, subscribing again to its channels.
+package client
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-generator/websocket"
+	ws "github.com/gorilla/websocket"
+	"github.com/satori/go.uuid"
+	"google.golang.org/protobuf/proto"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Codec is the envelope encoding used on the wire.
+type Codec int
+
+const (
+	JSON  Codec = iota // JSON envelopes in text frames
+	Proto              // proto envelopes in binary frames
+)
+
+// Message is a decoded envelope, whatever the codec.
+type Message = websocket.JsonMessage
+
+// Handler handles messages pushed by the server.
+type Handler func(message *Message)
+
+var (
+	ErrClosed         = errors.New("client is closed")
+	ErrDisconnected   = errors.New("connection lost before the reply")
+	ErrNoGreeting     = errors.New("server did not send the connected greeting")
+	ErrMalformedBatch = errors.New("malformed binary batch")
+)
+
+// ReplyError is returned by Call when the server replies with an error code.
+type ReplyError struct {
+	Code    int32
+	Message string
+}
+
+func (e *ReplyError) Error() string {
+	return fmt.Sprintf("websocket reply %d: %s", e.Code, e.Message)
+}
+
+type Client struct {
+	url          string
+	codec        Codec
+	header       http.Header
+	dialer       *ws.Dialer
+	minBackoff   time.Duration
+	maxBackoff   time.Duration
+	reconnect    bool
+	heartbeat    time.Duration
+	cancelOnDone bool
+	chunkSize    int
+	onMessage    Handler
+	onConnect    func(socketID string)
+	onDisconnect func(err error)
+	onError      func(err error)
+
+	mux           sync.Mutex
+	conn          *ws.Conn
+	socketID      string
+	ready         chan struct{}          // closed while connected
+	pending       map[string]chan result // request id -> reply
+	streams       map[string]Handler     // request id -> chunk handler of a streaming call
+	subscriptions map[string]Handler     // channel -> handler
+
+	writeMux  sync.Mutex // gorilla allows one concurrent writer
+	ctx       context.Context
+	cancel    context.CancelFunc
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+type result struct {
+	message *Message
+	err     error
+}
+
+// Dial connects to the engine at url and waits for the connected greeting.
+// The client then keeps the connection up until Close.
+func Dial(ctx context.Context, url string, opts ...Option) (*Client, error) {
+	c := &Client{
+		url:           batchURL(url),
+		dialer:        ws.DefaultDialer,
+		minBackoff:    MinBackoff,
+		maxBackoff:    MaxBackoff,
+		reconnect:     true,
+		heartbeat:     Heartbeat,
+		chunkSize:     ChunkSize,
+		ready:         make(chan struct{}),
+		pending:       make(map[string]chan result),
+		streams:       make(map[string]Handler),
+		subscriptions: make(map[string]Handler),
+		done:          make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt.apply(c)
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+
+	conn, socketID, err := c.dial(ctx)
+	if err != nil {
+		c.cancel()
+		return nil, err
+	}
+	c.attach(conn, socketID)
+	go c.run(conn)
+	if c.heartbeat > 0 {
+		go c.ping()
+	}
+	return c, nil
+}
+
+// SocketID returns the id the server gave the current connection, empty while disconnected.
+func (c *Client) SocketID() string {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.socketID
+}
+
+// Call sends command with data and waits for the reply. A reply with an error
+// code is returned along with a *ReplyError. While disconnected the call waits
+// for the client to reconnect, up to the ctx deadline.
+func (c *Client) Call(ctx context.Context, command string, data []byte) (*Message, error) {
+	return c.call(ctx, command, data, nil, nil)
+}
+
+// Stream calls a streaming command: chunk is run on the read goroutine with
+// every chunk in order, then the final reply is returned as by Call.
+func (c *Client) Stream(ctx context.Context, command string, data []byte, chunk Handler) (*Message, error) {
+	return c.call(ctx, command, data, nil, chunk)
+}
+
+func (c *Client) call(ctx context.Context, command string, data []byte, metadata map[string]string, chunk Handler) (*Message, error) {
+	requestID := uuid.NewV4().String()
+	reply := make(chan result, 1)
+	var (
+		conn     *ws.Conn
+		socketID string
+	)
+	for conn == nil {
+		c.mux.Lock()
+		conn, socketID = c.conn, c.socketID
+		ready := c.ready
+		if conn != nil {
+			c.pending[requestID] = reply
+			if chunk != nil {
+				c.streams[requestID] = chunk
+			}
+		}
+		c.mux.Unlock()
+		if conn != nil {
+			break
+		}
+		select {
+		case <-ready:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-c.ctx.Done():
+			return nil, ErrClosed
+		}
+	}
+
+	err := c.write(conn, &Message{
+		RequestId: requestID,
+		SocketId:  socketID,
+		Command:   command,
+		Data:      data,
+		Metadata:  metadata,
+	})
+	if err != nil {
+		c.forget(requestID)
+		return nil, err
+	}
+
+	select {
+	case r := <-reply:
+		if r.err != nil {
+			return nil, r.err
+		}
+		if r.message.Code >= http.StatusBadRequest {
+			return r.message, &ReplyError{Code: r.message.Code, Message: r.message.Message}
+		}
+		return r.message, nil
+	case <-ctx.Done():
+		if c.cancelOnDone {
+			c.abort(conn, socketID, requestID)
+		} else {
+			c.forget(requestID)
+		}
+		return nil, ctx.Err()
+	case <-c.ctx.Done():
+		return nil, ErrClosed
+	}
+}
+
+// abort asks the server to cancel the request requestID. The call keeps its
+// pending entry, so the cancelled reply is dropped like the cancel reply.
+func (c *Client) abort(conn *ws.Conn, socketID, requestID string) {
+	cancelID := uuid.NewV4().String()
+	c.mux.Lock()
+	c.pending[cancelID] = make(chan result, 1)
+	c.mux.Unlock()
+	err := c.write(conn, &Message{
+		RequestId: cancelID,
+		SocketId:  socketID,
+		Command:   websocket.CancelCommand,
+		Data:      []byte(requestID),
+	})
+	if err != nil {
+		c.forget(cancelID)
+		c.forget(requestID)
+	}
+}
+
+// Subscribe subscribes the connection to channel, messages published to it
+// with Engine.PublishMessage are passed to handler. The subscription is
+// renewed after every reconnect.
+func (c *Client) Subscribe(ctx context.Context, channel string, handler Handler) error {
+	c.mux.Lock()
+	c.subscriptions[channel] = handler
+	c.mux.Unlock()
+	if _, err := c.Call(ctx, websocket.SubscribeCommand, []byte(channel)); err != nil {
+		c.mux.Lock()
+		delete(c.subscriptions, channel)
+		c.mux.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Unsubscribe unsubscribes the connection from channel.
+func (c *Client) Unsubscribe(ctx context.Context, channel string) error {
+	c.mux.Lock()
+	delete(c.subscriptions, channel)
+	c.mux.Unlock()
+	_, err := c.Call(ctx, websocket.UnsubscribeCommand, []byte(channel))
+	return err
+}
+
+// Close closes the connection with a normal closure and stops reconnecting.
+func (c *Client) Close() error {
+	c.closeOnce.Do(func() {
+		c.cancel()
+		c.mux.Lock()
+		conn := c.conn
+		c.mux.Unlock()
+		if conn != nil {
+			c.writeMux.Lock()
+			_ = conn.WriteControl(ws.CloseMessage, ws.FormatCloseMessage(ws.CloseNormalClosure, ""), time.Now().Add(time.Second))
+			c.writeMux.Unlock()
+			_ = conn.Close()
+		}
+	})
+	return nil
+}
+
+// Done is closed once the client has stopped for good, after Close or a
+// disconnect without reconnect.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// dial connects and reads the connected greeting.
+func (c *Client) dial(ctx context.Context) (*ws.Conn, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, DialTimeout)
+	defer cancel()
+
+	dialer := *c.dialer
+	dialer.Subprotocols = []string{c.codec.Subprotocol()}
+	conn, _, err := dialer.DialContext(ctx, c.url, c.header)
+	if err != nil {
+		return nil, "", err
+	}
+	deadline, _ := ctx.Deadline()
+	_ = conn.SetReadDeadline(deadline)
+	messageType, data, err := conn.ReadMessage()
+	if err != nil {
+		_ = conn.Close()
+		return nil, "", err
+	}
+	messages, err := Decode(messageType, data)
+	if err != nil {
+		_ = conn.Close()
+		return nil, "", err
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+	for _, message := range messages {
+		if message.Command == websocket.Connected {
+			return conn, message.SocketId, nil
+		}
+	}
+	_ = conn.Close()
+	return nil, "", ErrNoGreeting
+}
+
+// attach makes conn the current connection.
+func (c *Client) attach(conn *ws.Conn, socketID string) {
+	c.mux.Lock()
+	c.conn, c.socketID = conn, socketID
+	close(c.ready)
+	c.mux.Unlock()
+	go c.connected(socketID)
+}
+
+// connected renews the subscriptions of a new connection, then runs onConnect.
+func (c *Client) connected(socketID string) {
+	c.mux.Lock()
+	channels := make([]string, 0, len(c.subscriptions))
+	for channel := range c.subscriptions {
+		channels = append(channels, channel)
+	}
+	c.mux.Unlock()
+	for _, channel := range channels {
+		ctx, cancel := context.WithTimeout(c.ctx, DialTimeout)
+		_, _ = c.Call(ctx, websocket.SubscribeCommand, []byte(channel))
+		cancel()
+	}
+	if c.onConnect != nil {
+		c.onConnect(socketID)
+	}
+}
+
+// detach drops the current connection after err and fails the pending calls.
+func (c *Client) detach(err error) {
+	c.mux.Lock()
+	conn, pending := c.conn, c.pending
+	c.conn, c.socketID = nil, ""
+	c.ready = make(chan struct{})
+	c.pending = make(map[string]chan result)
+	c.streams = make(map[string]Handler)
+	c.mux.Unlock()
+	if conn == nil {
+		return
+	}
+	_ = conn.Close()
+	for _, reply := range pending {
+		reply <- result{err: ErrDisconnected}
+	}
+	if c.onDisconnect != nil && c.ctx.Err() == nil {
+		c.onDisconnect(err)
+	}
+}
+
+// run reads conn and the connections replacing it until the client stops.
+func (c *Client) run(conn *ws.Conn) {
+	defer close(c.done)
+	for conn != nil {
+		err := c.readLoop(conn)
+		c.detach(err)
+		if !c.reconnect {
+			_ = c.Close()
+			return
+		}
+		conn = c.redial()
+	}
+}
+
+// redial dials with exponential backoff until it succeeds or the client is closed.
+func (c *Client) redial() *ws.Conn {
+	delay := c.minBackoff
+	for {
+		jitter := delay/2 + rand.N(delay/2+1)
+		select {
+		case <-time.After(jitter):
+		case <-c.ctx.Done():
+			return nil
+		}
+		conn, socketID, err := c.dial(c.ctx)
+		if err == nil {
+			c.attach(conn, socketID)
+			return conn
+		}
+		delay = min(delay*2, c.maxBackoff)
+	}
+}
+
+// readLoop dispatches the messages read from conn, undecodable frames are
+// reported to onError and skipped.
+func (c *Client) readLoop(conn *ws.Conn) error {
+	for {
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		messages, err := Decode(messageType, data)
+		if err != nil && c.onError != nil {
+			c.onError(err)
+		}
+		for _, message := range messages {
+			c.dispatch(message)
+		}
+	}
+}
+
+// dispatch passes message to the call it answers, or its chunk handler, else to its channel handler, else to onMessage.
+func (c *Client) dispatch(message *Message) {
+	c.mux.Lock()
+	if chunk := c.streams[message.RequestId]; chunk != nil && message.Metadata[websocket.MetadataStream] == websocket.StreamChunk {
+		c.mux.Unlock()
+		chunk(message)
+		return
+	}
+	reply, ok := c.pending[message.RequestId]
+	delete(c.pending, message.RequestId)
+	delete(c.streams, message.RequestId)
+	handler := c.subscriptions[message.Metadata[websocket.MetadataChannel]]
+	c.mux.Unlock()
+
+	switch {
+	case ok:
+		reply <- result{message: message}
+	case handler != nil && message.Metadata[websocket.MetadataChannel] != "":
+		handler(message)
+	case c.onMessage != nil:
+		c.onMessage(message)
+	}
+}
+
+// forget drops a pending call.
+func (c *Client) forget(requestID string) {
+	c.mux.Lock()
+	delete(c.pending, requestID)
+	delete(c.streams, requestID)
+	c.mux.Unlock()
+}
+
+// ping keeps the connection alive, the server counts pings as activity.
+func (c *Client) ping() {
+	ticker := time.NewTicker(c.heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.mux.Lock()
+			conn := c.conn
+			c.mux.Unlock()
+			if conn != nil {
+				_ = conn.WriteControl(ws.PingMessage, nil, time.Now().Add(c.heartbeat))
+			}
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+func (c *Client) write(conn *ws.Conn, message *Message) error {
+	data, err := c.codec.Encode(message)
+	if err != nil {
+		return err
+	}
+	c.writeMux.Lock()
+	defer c.writeMux.Unlock()
+	return conn.WriteMessage(c.codec.MessageType(), data)
+}
+
+// Subprotocol returns the websocket subprotocol asking the server for codec.
+func (codec Codec) Subprotocol() string {
+	if codec == Proto {
+		return websocket.SubprotocolProto
+	}
+	return websocket.SubprotocolJSON
+}
+
+// MessageType returns the websocket frame type of codec.
+func (codec Codec) MessageType() int {
+	if codec == Proto {
+		return ws.BinaryMessage
+	}
+	return ws.TextMessage
+}
+
+// Encode encodes message as a codec envelope.
+func (codec Codec) Encode(message *Message) ([]byte, error) {
+	if codec == Proto {
+		return proto.Marshal(&websocket.ProtoMessage{
+			RequestId: message.RequestId,
+			SocketId:  message.SocketId,
+			Command:   message.Command,
+			Code:      message.Code,
+			Message:   message.Message,
+			Data:      message.Data,
+			Metadata:  message.Metadata,
+		})
+	}
+	return json.Marshal(message)
+}
+
+// batchURL adds the websocket.BatchQuery parameter to raw, the client decodes
+// the batches of engines with websocket.WithBatching.
+func batchURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	query := u.Query()
+	query.Set(websocket.BatchQuery, "true")
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// Decode decodes the messages of a frame, following the frame type rather
+// than the codec. Text batches are JSON arrays; binary batches start with a
+// zero byte and hold proto messages each prefixed with its big-endian uint32
+// length, see websocket.WithBatching.
+func Decode(messageType int, data []byte) (messages []*Message, err error) {
+	if messageType == ws.BinaryMessage {
+		if len(data) == 0 || data[0] != 0 {
+			message, err := decodeProto(data)
+			if err != nil {
+				return nil, err
+			}
+			return []*Message{message}, nil
+		}
+		for len(data) > 0 {
+			if len(data) < 4 || int(binary.BigEndian.Uint32(data)) > len(data)-4 {
+				return nil, ErrMalformedBatch
+			}
+			size := binary.BigEndian.Uint32(data)
+			message, err := decodeProto(data[4 : 4+size])
+			if err != nil {
+				return nil, err
+			}
+			messages = append(messages, message)
+			data = data[4+size:]
+		}
+		return messages, nil
+	}
+	if len(data) > 0 && data[0] == '[' {
+		err = json.Unmarshal(data, &messages)
+		return
+	}
+	var message Message
+	if err = json.Unmarshal(data, &message); err != nil {
+		return nil, err
+	}
+	return []*Message{&message}, nil
+}
+
+// decodeProto decodes a proto envelope.
+func decodeProto(data []byte) (*Message, error) {
+	var message websocket.ProtoMessage
+	if err := proto.Unmarshal(data, &message); err != nil {
+		return nil, err
+	}
+	return &Message{
+		RequestId: message.RequestId,
+		SocketId:  message.SocketId,
+		Command:   message.Command,
+		Code:      message.Code,
+		Message:   message.Message,
+		Data:      message.Data,
+		Metadata:  message.Metadata,
+	}, nil
+}
@@ -0,0 +1,315 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-generator/websocket"
+	ws "github.com/gorilla/websocket"
+)
+
+func newServer(t *testing.T) (*websocket.Engine, string) {
+	e := websocket.NewEngineWithOptions(websocket.WithSlog(slog.New(slog.DiscardHandler)))
+	e.RegisterSubscribeRouter()
+	e.RegisterJsonRouter("echo", func(message *websocket.JsonMessage) {
+		message.Code = http.StatusOK
+	})
+	e.RegisterProtoRouter("echo", func(message *websocket.ProtoMessage) {
+		message.Code = http.StatusOK
+		message.Message = "proto"
+	})
+	mux := http.NewServeMux()
+	mux.Handle("/ws", e)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return e, "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+}
+
+func TestCall(t *testing.T) {
+	_, url := newServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, codec := range []Codec{JSON, Proto} {
+		c, err := Dial(ctx, url, WithCodec(codec))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.SocketID() == "" {
+			t.Error("socket id not learnt from the greeting")
+		}
+		reply, err := c.Call(ctx, "echo", []byte("hi"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(reply.Data) != "hi" || reply.Code != http.StatusOK {
+			t.Errorf("codec %d: reply %+v", codec, reply)
+		}
+		if codec == Proto && reply.Message != "proto" {
+			t.Errorf("proto call answered by the %q handler", reply.Message)
+		}
+
+		var replyErr *ReplyError
+		if _, err = c.Call(ctx, "missing", nil); !errors.As(err, &replyErr) || replyErr.Code != http.StatusBadRequest {
+			t.Errorf("unknown command error = %v", err)
+		}
+		_ = c.Close()
+		<-c.Done()
+	}
+}
+
+func TestSubscribeAndReconnect(t *testing.T) {
+	e, url := newServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	connects := make(chan string, 4)
+	c, err := Dial(ctx, url, WithBackoff(10*time.Millisecond, 50*time.Millisecond), WithOnConnect(func(socketID string) {
+		connects <- socketID
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	first := <-connects
+
+	received := make(chan *Message, 4)
+	if err = c.Subscribe(ctx, "news", func(message *Message) {
+		received <- message
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err = e.PublishMessage("news", "headline", []byte("one")); err != nil {
+		t.Fatal(err)
+	}
+	if message := <-received; message.Command != "headline" || string(message.Data) != "one" {
+		t.Errorf("received %+v", message)
+	}
+
+	if err = e.Kick(first, 0, "test"); err != nil {
+		t.Fatal(err)
+	}
+	var second string
+	select {
+	case second = <-connects:
+	case <-ctx.Done():
+		t.Fatal("client did not reconnect")
+	}
+	if second == first {
+		t.Fatal("reconnected with the same socket id")
+	}
+	if err = e.PublishMessage("news", "headline", []byte("two")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case message := <-received:
+		if string(message.Data) != "two" {
+			t.Errorf("received %+v after reconnect", message)
+		}
+	case <-ctx.Done():
+		t.Fatal("subscription not renewed after reconnect")
+	}
+}
+
+func TestCancelOnDone(t *testing.T) {
+	e, url := newServer(t)
+	e.RegisterCancelRouter()
+	causes := make(chan error, 1)
+	e.RegisterJsonContextRouter("slow", func(ctx context.Context, message *websocket.JsonMessage) {
+		<-ctx.Done()
+		causes <- context.Cause(ctx)
+	}, websocket.WithAsync())
+
+	c, err := Dial(context.Background(), url, WithCancelOnDone())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err = c.Call(ctx, "slow", nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("call returned %v", err)
+	}
+	select {
+	case cause := <-causes:
+		if !errors.Is(cause, websocket.ErrRequestCancelled) {
+			t.Fatalf("handler cancelled with %v", cause)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler not cancelled")
+	}
+}
+
+func TestStream(t *testing.T) {
+	e, url := newServer(t)
+	count := func(stream *websocket.Stream) error {
+		for _, chunk := range []string{"a", "b", "c"} {
+			if err := stream.Send([]byte(chunk)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	e.RegisterJsonStreamRouter("count", func(ctx context.Context, message *websocket.JsonMessage, stream *websocket.Stream) error {
+		return count(stream)
+	})
+	e.RegisterProtoStreamRouter("count", func(ctx context.Context, message *websocket.ProtoMessage, stream *websocket.Stream) error {
+		return count(stream)
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, codec := range []Codec{JSON, Proto} {
+		c, err := Dial(ctx, url, WithCodec(codec))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var chunks []string
+		reply, err := c.Stream(ctx, "count", nil, func(message *Message) {
+			chunks = append(chunks, string(message.Data))
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(chunks, "") != "abc" || reply.Metadata[websocket.MetadataStream] != websocket.StreamEnd ||
+			reply.Metadata[websocket.MetadataSeq] != "4" {
+			t.Fatalf("codec %d: chunks %q, end %+v", codec, chunks, reply)
+		}
+		_ = c.Close()
+	}
+}
+
+// kickingReader kicks the connection of the client once the upload reads past half.
+type kickingReader struct {
+	*bytes.Reader
+	kick func()
+	once sync.Once
+}
+
+func (r *kickingReader) ReadAt(p []byte, offset int64) (int, error) {
+	if offset >= r.Size()/2 {
+		r.once.Do(r.kick)
+	}
+	return r.Reader.ReadAt(p, offset)
+}
+
+func TestUpload(t *testing.T) {
+	e, url := newServer(t)
+	uploads := make(chan string, 1)
+	e.RegisterJsonUploadRouter("blob", func(ctx context.Context, message *websocket.JsonMessage, upload *websocket.Upload) error {
+		data, err := io.ReadAll(upload.Reader)
+		uploads <- upload.Metadata["name"] + ":" + string(data)
+		message.Metadata = map[string]string{"url": "/blobs/notes"}
+		return err
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c, err := Dial(ctx, url, WithChunkSize(4), WithBackoff(10*time.Millisecond, 50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	first, content := c.SocketID(), "a blob sent in many small chunks"
+	r := &kickingReader{Reader: bytes.NewReader([]byte(content)), kick: func() {
+		_ = e.Kick(c.SocketID(), 0, "test")
+	}}
+	reply, err := c.Upload(ctx, "blob", r, r.Size(), map[string]string{"name": "notes"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Metadata[websocket.MetadataOffset] != strconv.Itoa(len(content)) || reply.Metadata["url"] != "/blobs/notes" {
+		t.Fatalf("last chunk reply %+v", reply)
+	}
+	if upload := <-uploads; upload != "notes:"+content {
+		t.Fatalf("server received %q", upload)
+	}
+	if c.SocketID() == first {
+		t.Fatal("upload not resumed on another connection")
+	}
+}
+
+func TestDecodeBinaryBatch(t *testing.T) {
+	var batch []byte
+	for _, command := range []string{"one", "two"} {
+		data, err := Proto.Encode(&Message{RequestId: command, Command: command})
+		if err != nil {
+			t.Fatal(err)
+		}
+		batch = binary.BigEndian.AppendUint32(batch, uint32(len(data)))
+		batch = append(batch, data...)
+	}
+	messages, err := Decode(ws.BinaryMessage, batch)
+	if err != nil || len(messages) != 2 || messages[0].Command != "one" || messages[1].Command != "two" {
+		t.Fatalf("decoded %v: %v", messages, err)
+	}
+	if _, err = Decode(ws.BinaryMessage, batch[:len(batch)-1]); !errors.Is(err, ErrMalformedBatch) {
+		t.Fatalf("truncated batch decoded with %v", err)
+	}
+	if messages, err = Decode(ws.BinaryMessage, batch[4:4+binary.BigEndian.Uint32(batch)]); err != nil || len(messages) != 1 {
+		t.Fatalf("lone message decoded %v: %v", messages, err)
+	}
+}
+
+func TestBatchedProtoAndDecodeErrors(t *testing.T) {
+	e := websocket.NewEngineWithOptions(websocket.WithSlog(slog.New(slog.DiscardHandler)),
+		websocket.WithClientOptions(websocket.WithBatching(0, 50*time.Millisecond)))
+	e.RegisterSubscribeRouter()
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	errs := make(chan error, 1)
+	c, err := Dial(ctx, "ws"+strings.TrimPrefix(srv.URL, "http"), WithCodec(Proto), WithOnError(func(err error) {
+		errs <- err
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	received := make(chan *Message, 3)
+	if err = c.Subscribe(ctx, "news", func(message *Message) {
+		received <- message
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for i := range 3 {
+		if err = e.PublishMessage("news", "headline", []byte(strconv.Itoa(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := range 3 {
+		select {
+		case message := <-received:
+			if message.Command != "headline" {
+				t.Errorf("message %d: %+v", i, message)
+			}
+		case <-ctx.Done():
+			t.Fatalf("received %d of 3 batched messages", i)
+		}
+	}
+
+	if err = e.Publish("news", []byte{0, 0, 0, 9, 1}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err = <-errs:
+		if !errors.Is(err, ErrMalformedBatch) {
+			t.Fatalf("decode error %v", err)
+		}
+	case <-ctx.Done():
+		t.Fatal("decode error not reported")
+	}
+}
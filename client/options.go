@@ -0,0 +1,115 @@
+package client
+
+import (
+	ws "github.com/gorilla/websocket"
+	"net/http"
+	"time"
+)
+
+const (
+	MinBackoff  = 500 * time.Millisecond // default first reconnect delay
+	MaxBackoff  = 30 * time.Second       // default reconnect delay cap
+	Heartbeat   = 30 * time.Second       // default ping interval
+	DialTimeout = 10 * time.Second       // default time allowed to dial and read the greeting
+	ChunkSize   = 256 << 10              // default size of the upload chunks
+)
+
+type (
+	Option interface {
+		apply(*Client)
+	}
+	optionFunc func(*Client)
+)
+
+func (f optionFunc) apply(c *Client) {
+	f(c)
+}
+
+// WithCodec sets the envelope codec, JSON by default.
+func WithCodec(codec Codec) Option {
+	return optionFunc(func(c *Client) {
+		c.codec = codec
+	})
+}
+
+// WithHeader sets the HTTP headers of the handshake, e.g. Authorization.
+func WithHeader(header http.Header) Option {
+	return optionFunc(func(c *Client) {
+		c.header = header
+	})
+}
+
+// WithDialer sets the dialer, ws.DefaultDialer by default.
+func WithDialer(dialer *ws.Dialer) Option {
+	return optionFunc(func(c *Client) {
+		c.dialer = dialer
+	})
+}
+
+// WithBackoff sets the reconnect delays: min first, doubled after every failed
+// attempt up to max, with jitter.
+func WithBackoff(min, max time.Duration) Option {
+	return optionFunc(func(c *Client) {
+		c.minBackoff, c.maxBackoff = min, max
+	})
+}
+
+// WithoutReconnect makes the client stop at the first disconnect.
+func WithoutReconnect() Option {
+	return optionFunc(func(c *Client) {
+		c.reconnect = false
+	})
+}
+
+// WithHeartbeat sets the ping interval keeping the connection alive, 0 disables pings.
+func WithHeartbeat(interval time.Duration) Option {
+	return optionFunc(func(c *Client) {
+		c.heartbeat = interval
+	})
+}
+
+// WithCancelOnDone makes Call send a cancel command for its request when its
+// context is done before the reply; the server must register the cancel router.
+func WithCancelOnDone() Option {
+	return optionFunc(func(c *Client) {
+		c.cancelOnDone = true
+	})
+}
+
+// WithChunkSize sets the size of the chunks sent by Upload, it must fit the
+// message size limit of the server.
+func WithChunkSize(size int) Option {
+	return optionFunc(func(c *Client) {
+		c.chunkSize = size
+	})
+}
+
+// WithOnMessage sets the handler of pushed messages that answer no call and
+// belong to no subscribed channel, e.g. raw publishes.
+func WithOnMessage(handler Handler) Option {
+	return optionFunc(func(c *Client) {
+		c.onMessage = handler
+	})
+}
+
+// WithOnConnect sets a callback run after every (re)connection with the new socket id.
+func WithOnConnect(fn func(socketID string)) Option {
+	return optionFunc(func(c *Client) {
+		c.onConnect = fn
+	})
+}
+
+// WithOnDisconnect sets a callback run when a connection is lost.
+func WithOnDisconnect(fn func(err error)) Option {
+	return optionFunc(func(c *Client) {
+		c.onDisconnect = fn
+	})
+}
+
+// WithOnError sets a callback run with the error of every frame the client
+// cannot decode, the frame is then skipped.
+func WithOnError(fn func(err error)) Option {
+	return optionFunc(func(c *Client) {
+		c.onError = fn
+	})
+}
@@ -0,0 +1,96 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/gin-generator/websocket"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// Upload sends size bytes of r to an upload command in chunks, see
+// Engine.RegisterJsonUploadRouter, and returns the reply to the last chunk.
+// metadata go with the open frame, along with the size and sha256 of the
+// content. After a reconnect the upload is opened again with its id and
+// resumes from the offset the server got to; losing the connection before
+// the reply to the last chunk finds the upload complete when opening it
+// again, and the empty chunk at its end gets the reply the server kept.
+func (c *Client) Upload(ctx context.Context, command string, r io.ReaderAt, size int64, metadata map[string]string) (*Message, error) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, io.NewSectionReader(r, 0, size)); err != nil {
+		return nil, err
+	}
+	open := make(map[string]string, len(metadata)+3)
+	for key, value := range metadata {
+		open[key] = value
+	}
+	open[websocket.MetadataUpload] = websocket.UploadOpen
+	open[websocket.MetadataSize] = strconv.FormatInt(size, 10)
+	open[websocket.MetadataChecksum] = hex.EncodeToString(hash.Sum(nil))
+
+	var (
+		uploadID string
+		offset   int64
+		opened   bool
+		chunk    = make([]byte, min(int64(c.chunkSize), size))
+	)
+	for {
+		if !opened {
+			reply, err := c.call(ctx, command, nil, open, nil)
+			if c.lost(ctx, err) {
+				continue
+			}
+			if err != nil {
+				return reply, err
+			}
+			if uploadID, offset, err = uploadOffset(reply); err != nil {
+				return reply, err
+			}
+			open[websocket.MetadataUploadID], opened = uploadID, true
+		}
+
+		n, err := r.ReadAt(chunk[:min(int64(len(chunk)), size-offset)], offset)
+		if err != nil && !(errors.Is(err, io.EOF) && offset+int64(n) == size) {
+			return nil, err
+		}
+		reply, err := c.call(ctx, command, chunk[:n], map[string]string{
+			websocket.MetadataUpload:   websocket.UploadChunk,
+			websocket.MetadataUploadID: uploadID,
+			websocket.MetadataOffset:   strconv.FormatInt(offset, 10),
+		}, nil)
+		var replyErr *ReplyError
+		switch {
+		case c.lost(ctx, err):
+			opened = false
+			continue
+		case errors.As(err, &replyErr) && replyErr.Code == http.StatusConflict:
+		case err != nil:
+			return reply, err
+		}
+		if _, offset, err = uploadOffset(reply); err != nil {
+			return reply, err
+		}
+		if offset >= size {
+			return reply, nil
+		}
+	}
+}
+
+// lost reports whether err is the connection failing under a call, which
+// Upload retries on the next connection.
+func (c *Client) lost(ctx context.Context, err error) bool {
+	var replyErr *ReplyError
+	return err != nil && !errors.As(err, &replyErr) && ctx.Err() == nil && c.ctx.Err() == nil
+}
+
+// uploadOffset reads the upload id and next offset of an upload reply.
+func uploadOffset(reply *Message) (string, int64, error) {
+	offset, err := strconv.ParseInt(reply.Metadata[websocket.MetadataOffset], 10, 64)
+	if err != nil {
+		return "", 0, errors.New("upload reply without an offset")
+	}
+	return reply.Metadata[websocket.MetadataUploadID], offset, nil
+}
@@ -0,0 +1,24 @@
+package websocket
+
+import "time"
+
+// Clock is the time source of idle timeouts, pings, the timing wheel and rate
+// limits. The engine uses the wall clock unless WithClock sets another, e.g.
+// the fake clock of the wstest package.
+type Clock interface {
+	Now() time.Time
+	// NewTicker returns a channel delivering the time every d and a function stopping it.
+	NewTicker(d time.Duration) (<-chan time.Time, func())
+}
+
+// wallClock is the Clock of the time package.
+type wallClock struct{}
+
+func (wallClock) Now() time.Time {
+	return time.Now()
+}
+
+func (wallClock) NewTicker(d time.Duration) (<-chan time.Time, func()) {
+	ticker := time.NewTicker(d)
+	return ticker.C, ticker.Stop
+}
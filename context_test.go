@@ -0,0 +1,66 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	ws "github.com/gorilla/websocket"
+)
+
+func TestClientContext(t *testing.T) {
+	e := newTestEngine()
+	defer e.Shutdown(t.Context())
+	var handlerCtx context.Context
+	e.RegisterJsonContextRouter("capture", func(ctx context.Context, m *JsonMessage) {
+		handlerCtx = ctx
+	})
+
+	c := e.NewSyntheticClient(WithClientValues(map[any]any{"tenant": "acme"}))
+	if c.Value("tenant") != "acme" || ClientFromContext(c) != c {
+		t.Fatal("initial values not visible")
+	}
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.SetValue(i, i)
+			_ = c.Value(i)
+		}()
+	}
+	wg.Wait()
+
+	c.Dispatch(ws.TextMessage, []byte(`{"request_id":"1","socket_id":"`+c.ID()+`","command":"capture"}`))
+	if ClientFromContext(handlerCtx) != c {
+		t.Fatal("handler context does not carry the client")
+	}
+	if !errors.Is(handlerCtx.Err(), context.Canceled) {
+		t.Fatal("handler context outlived its request")
+	}
+	child, cancel := context.WithTimeout(c, time.Hour)
+	defer cancel()
+	if c.Err() != nil {
+		t.Fatal("context done before release")
+	}
+
+	if err := e.Kick(c.ID(), 0, "bye"); err != nil {
+		t.Fatal(err)
+	}
+	for _, ctx := range []context.Context{c, child} {
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Second):
+			t.Fatal("context not done after release")
+		}
+		if !errors.Is(ctx.Err(), context.Canceled) {
+			t.Fatalf("Err() = %v", ctx.Err())
+		}
+		var reason DisconnectReason
+		if !errors.As(context.Cause(ctx), &reason) || reason.Kind != DisconnectKick || reason.Text != "bye" {
+			t.Fatalf("Cause() = %v", context.Cause(ctx))
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package websocket
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"net"
+)
+
+// DisconnectKind tells why a client went away.
+type DisconnectKind int
+
+const (
+	DisconnectUnknown       DisconnectKind = iota
+	DisconnectClientClose                  // the peer closed the connection
+	DisconnectIdleTimeout                  // no heartbeat within breakTime
+	DisconnectKick                         // the server kicked the client
+	DisconnectWriteFailure                 // writing to the socket failed
+	DisconnectReadFailure                  // reading from the socket failed
+	DisconnectShutdown                     // the engine is shutting down
+	DisconnectProtocolError                // the peer violated the websocket protocol
+	DisconnectSlowConsumer                 // the outbound queue overflowed
+	DisconnectRateLimited                  // the client exceeded a rate limit
+	DisconnectMessageTooBig                // the client sent a message over a size limit
+)
+
+var disconnectKinds = map[DisconnectKind]string{
+	DisconnectUnknown:       "unknown",
+	DisconnectClientClose:   "client_close",
+	DisconnectIdleTimeout:   "idle_timeout",
+	DisconnectKick:          "kick",
+	DisconnectWriteFailure:  "write_failure",
+	DisconnectReadFailure:   "read_failure",
+	DisconnectShutdown:      "shutdown",
+	DisconnectProtocolError: "protocol_error",
+	DisconnectSlowConsumer:  "slow_consumer",
+	DisconnectRateLimited:   "rate_limited",
+	DisconnectMessageTooBig: "message_too_big",
+}
+
+func (k DisconnectKind) String() string {
+	if name, ok := disconnectKinds[k]; ok {
+		return name
+	}
+	return disconnectKinds[DisconnectUnknown]
+}
+
+// DisconnectReason records why a client went away. Code is the RFC 6455 close
+// code received from the peer or sent by the server, CloseAbnormalClosure when
+// the connection dropped without a close frame.
+type DisconnectReason struct {
+	Kind DisconnectKind
+	Code int
+	Text string
+	Err  error
+}
+
+func (r DisconnectReason) String() string {
+	if r.Err != nil {
+		return fmt.Sprintf("%s (%d %s): %s", r.Kind, r.Code, r.Text, r.Err.Error())
+	}
+	return fmt.Sprintf("%s (%d %s)", r.Kind, r.Code, r.Text)
+}
+
+// Error makes the reason the cause of a released client context, see context.Cause.
+func (r DisconnectReason) Error() string {
+	return r.String()
+}
+
+// Unwrap returns the underlying error, if any.
+func (r DisconnectReason) Unwrap() error {
+	return r.Err
+}
+
+// readFailureReason classifies an error returned by the read loop.
+func readFailureReason(err error) DisconnectReason {
+	var closeErr *websocket.CloseError
+	if errors.As(err, &closeErr) && closeErr.Code == websocket.CloseAbnormalClosure { // dropped without a close frame
+		return DisconnectReason{Kind: DisconnectReadFailure, Code: websocket.CloseAbnormalClosure, Err: err}
+	}
+	if errors.As(err, &closeErr) {
+		return DisconnectReason{Kind: DisconnectClientClose, Code: closeErr.Code, Text: closeErr.Text}
+	}
+	if errors.Is(err, websocket.ErrReadLimit) {
+		return DisconnectReason{Kind: DisconnectMessageTooBig, Code: websocket.CloseMessageTooBig, Err: err}
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return DisconnectReason{Kind: DisconnectReadFailure, Code: websocket.CloseAbnormalClosure, Err: err}
+	}
+	// gorilla has already sent a protocol error close frame for anything else
+	return DisconnectReason{Kind: DisconnectProtocolError, Code: websocket.CloseProtocolError, Err: err}
+}
+
+// writeFailureReason wraps an error returned while writing to the socket.
+func writeFailureReason(err error) DisconnectReason {
+	return DisconnectReason{Kind: DisconnectWriteFailure, Code: websocket.CloseAbnormalClosure, Err: err}
+}
@@ -0,0 +1,116 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	ws "github.com/gorilla/websocket"
+)
+
+func TestReadFailureReason(t *testing.T) {
+	netErr := &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")}
+	for _, tc := range []struct {
+		err  error
+		kind DisconnectKind
+		code int
+		text string
+	}{
+		{&ws.CloseError{Code: ws.CloseNormalClosure, Text: "bye"}, DisconnectClientClose, ws.CloseNormalClosure, "bye"},
+		{&ws.CloseError{Code: ws.CloseGoingAway}, DisconnectClientClose, ws.CloseGoingAway, ""},
+		{fmt.Errorf("read: %w", &ws.CloseError{Code: 4001, Text: "app"}), DisconnectClientClose, 4001, "app"},
+		{ws.ErrReadLimit, DisconnectMessageTooBig, ws.CloseMessageTooBig, ""},
+		{&ws.CloseError{Code: ws.CloseAbnormalClosure, Text: "unexpected EOF"}, DisconnectReadFailure, ws.CloseAbnormalClosure, ""},
+		{netErr, DisconnectReadFailure, ws.CloseAbnormalClosure, ""},
+		{errors.New("bad rsv bits"), DisconnectProtocolError, ws.CloseProtocolError, ""},
+	} {
+		reason := readFailureReason(tc.err)
+		if reason.Kind != tc.kind || reason.Code != tc.code || reason.Text != tc.text {
+			t.Errorf("%v: reason %s", tc.err, reason)
+		}
+	}
+
+	reason := writeFailureReason(netErr)
+	if reason.Kind != DisconnectWriteFailure || reason.Code != ws.CloseAbnormalClosure || !errors.Is(reason, netErr) {
+		t.Errorf("write failure reason %s", reason)
+	}
+}
+
+func TestDisconnectReasons(t *testing.T) {
+	e := newTestEngine(WithReconnectHint("come back later"))
+	url, clients := serveEngine(t, e)
+
+	reasons := make(chan DisconnectReason, 1)
+	e.hooks.onDisconnect = append(e.hooks.onDisconnect, func(c *Client, reason DisconnectReason, _ time.Duration) {
+		reasons <- reason
+	})
+	expect := func(kind DisconnectKind, code int, text string) {
+		t.Helper()
+		select {
+		case reason := <-reasons:
+			if reason.Kind != kind || reason.Code != code || reason.Text != text {
+				t.Fatalf("reason %s, want %s (%d %s)", reason, kind, code, text)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no %s disconnect", kind)
+		}
+	}
+
+	// the peer closes with an application code
+	conn, _, err := ws.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-clients
+	_ = conn.WriteMessage(ws.CloseMessage, ws.FormatCloseMessage(4001, "done"))
+	expect(DisconnectClientClose, 4001, "done")
+	conn.Close()
+
+	// the peer drops the connection without a close frame
+	conn, _, err = ws.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-clients
+	conn.Close()
+	expect(DisconnectReadFailure, ws.CloseAbnormalClosure, "")
+
+	// a kick sends its code and reason to the peer
+	conn, _, err = ws.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := <-clients
+	if err = e.Kick(client.ID(), 4000, "banned"); err != nil {
+		t.Fatal(err)
+	}
+	for err == nil {
+		_, _, err = conn.ReadMessage()
+	}
+	if closeErr := (*ws.CloseError)(nil); !errors.As(err, &closeErr) || closeErr.Code != 4000 || closeErr.Text != "banned" {
+		t.Fatalf("kicked peer read %v", err)
+	}
+	expect(DisconnectKick, 4000, "banned")
+	conn.Close()
+
+	// synthetic clients record the same reasons
+	client = e.NewSyntheticClient()
+	_ = e.Kick(client.ID(), 0, "")
+	expect(DisconnectKick, ws.ClosePolicyViolation, "")
+
+	client = e.NewSyntheticClient(WithSendLimit(1), WithBackpressure(DisconnectSlow, 0))
+	_ = client.Send([]byte("1"))
+	if err = client.Send([]byte("2")); !errors.Is(err, ErrSlowConsumer) {
+		t.Fatalf("send over the limit returned %v", err)
+	}
+	expect(DisconnectSlowConsumer, ws.ClosePolicyViolation, "slow consumer")
+
+	e.NewSyntheticClient()
+	if err = e.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	expect(DisconnectShutdown, ws.CloseGoingAway, "come back later")
+}
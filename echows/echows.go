@@ -0,0 +1,13 @@
+// Package echows adapts the websocket engine to echo.
+package echows
+
+import (
+	"github.com/gin-generator/websocket"
+	"github.com/labstack/echo/v4"
+)
+
+// Connect returns an echo handler upgrading requests to connections of engine
+// with the client options opts, like ginws.Connect does for gin.
+func Connect(engine *websocket.Engine, opts ...websocket.Option) echo.HandlerFunc {
+	return echo.WrapHandler(engine.Handler(opts...))
+}
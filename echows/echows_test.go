@@ -0,0 +1,46 @@
+package echows
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-generator/websocket"
+	ws "github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+)
+
+func TestConnect(t *testing.T) {
+	engine := websocket.NewEngineWithOptions(websocket.WithSlog(slog.New(slog.DiscardHandler)))
+	defer engine.Shutdown(t.Context())
+	engine.RegisterJsonRouter("ping", func(m *websocket.JsonMessage) {
+		m.Code, m.Message = http.StatusOK, "pong"
+	})
+
+	e := echo.New()
+	e.GET("/ws", Connect(engine))
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+
+	conn, _, err := ws.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var greeting websocket.JsonMessage
+	if err = conn.ReadJSON(&greeting); err != nil {
+		t.Fatal(err)
+	}
+	if err = conn.WriteJSON(websocket.JsonMessage{RequestId: "1", SocketId: greeting.SocketId, Command: "ping"}); err != nil {
+		t.Fatal(err)
+	}
+	var reply websocket.JsonMessage
+	if err = conn.ReadJSON(&reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.Message != "pong" {
+		t.Fatalf("reply %+v", reply)
+	}
+}
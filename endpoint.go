@@ -0,0 +1,115 @@
+package websocket
+
+import (
+	"errors"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"strings"
+)
+
+// ErrCommandNotAllowed is replied to commands the endpoint of the client does not allow.
+var ErrCommandNotAllowed = errors.New("command not allowed on this endpoint")
+
+// endpoint is the configuration of one upgrade route of an Engine. The engine
+// settings are its defaults, so endpoints only carry what they change.
+type endpoint struct {
+	name            string
+	clientOptions   []Option
+	readBufferSize  int
+	writeBufferSize int
+	maxMessageSize  int64
+	checkOrigin     func(r *http.Request) bool
+	userResolver    UserResolver
+	onConnect       []ConnectHook
+	protocols       []int    // allowed message types, empty allows both
+	allow           []string // allowed command patterns, empty allows all
+	deny            []string // denied command patterns
+}
+
+// newEndpoint returns an endpoint with the engine settings and opts applied.
+func (e *Engine) newEndpoint(opts ...EndpointOption) *endpoint {
+	ep := &endpoint{
+		clientOptions:   e.clientOptions[:len(e.clientOptions):len(e.clientOptions)],
+		readBufferSize:  e.readBufferSize,
+		writeBufferSize: e.writeBufferSize,
+		maxMessageSize:  e.maxMessageSize,
+		userResolver:    e.userResolver,
+	}
+	for _, opt := range opts {
+		opt.apply(ep)
+	}
+	return ep
+}
+
+// Endpoint returns an http.Handler upgrading requests with its own settings,
+// so one engine can serve e.g. a public and an internal route with different
+// auth, codecs, limits and commands while sharing clients, channels and stats.
+func (e *Engine) Endpoint(opts ...EndpointOption) http.Handler {
+	ep := e.newEndpoint(opts...)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e.serve(w, r, ep)
+	})
+}
+
+// connect runs the connect hooks of the endpoint in order, the first error refuses the connection.
+func (ep *endpoint) connect(client *Client, r *http.Request) error {
+	for _, hook := range ep.onConnect {
+		if err := hook(client, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// subprotocols returns the subprotocols of the allowed codecs.
+func (ep *endpoint) subprotocols() []string {
+	var subprotocols []string
+	if ep.allowsProtocol(websocket.TextMessage) {
+		subprotocols = append(subprotocols, SubprotocolJSON)
+	}
+	if ep.allowsProtocol(websocket.BinaryMessage) {
+		subprotocols = append(subprotocols, SubprotocolProto)
+	}
+	return subprotocols
+}
+
+// allowsProtocol reports whether clients may send messages of type protocol.
+func (ep *endpoint) allowsProtocol(protocol int) bool {
+	if ep == nil || len(ep.protocols) == 0 {
+		return true
+	}
+	for _, allowed := range ep.protocols {
+		if allowed == protocol {
+			return true
+		}
+	}
+	return false
+}
+
+// allows reports whether clients may invoke command: it must match an allowed
+// pattern, when there are any, and no denied one.
+func (ep *endpoint) allows(command string) bool {
+	if ep == nil {
+		return true
+	}
+	if len(ep.allow) > 0 && !matchCommand(ep.allow, command) {
+		return false
+	}
+	return !matchCommand(ep.deny, command)
+}
+
+// matchCommand reports whether command matches one of patterns. A pattern
+// ending with * matches a namespace, e.g. "admin.*" matches "admin.kick";
+// any other pattern matches the command exactly.
+func matchCommand(patterns []string, command string) bool {
+	for _, pattern := range patterns {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(command, prefix) {
+				return true
+			}
+		} else if pattern == command {
+			return true
+		}
+	}
+	return false
+}
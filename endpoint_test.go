@@ -0,0 +1,161 @@
+package websocket
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	ws "github.com/gorilla/websocket"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestMatchCommand(t *testing.T) {
+	ep := &endpoint{allow: []string{"chat.*", "ping"}, deny: []string{"chat.admin*"}}
+	for command, want := range map[string]bool{
+		"ping":        true,
+		"pingpong":    false,
+		"chat.send":   true,
+		"chat.admin":  false,
+		"chat.admins": false,
+		"admin.kick":  false,
+	} {
+		if got := ep.allows(command); got != want {
+			t.Errorf("allows(%q) = %v, want %v", command, got, want)
+		}
+	}
+	if !(*endpoint)(nil).allows("anything") {
+		t.Error("a nil endpoint must allow every command")
+	}
+}
+
+func TestEndpoints(t *testing.T) {
+	e := newTestEngine()
+	defer e.Shutdown(t.Context())
+	for _, command := range []string{"chat.send", "admin.kick"} {
+		e.RegisterJsonRouter(command, func(m *JsonMessage) {
+			m.Code = http.StatusOK
+		})
+		e.RegisterProtoRouter(command, func(m *ProtoMessage) {
+			m.Code = http.StatusOK
+		})
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/ws/public", e.Endpoint(
+		WithCodecs(ws.TextMessage),
+		WithAllowedCommands("chat.*"),
+	))
+	mux.Handle("/ws/internal", e.Endpoint(
+		WithCodecs(ws.BinaryMessage),
+		WithEndpointUserResolver(func(r *http.Request) (string, error) {
+			if r.Header.Get("Authorization") != "Bearer internal" {
+				return "", ErrUnauthorized
+			}
+			return "ops", nil
+		}),
+	))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	public, _, err := ws.DefaultDialer.Dial(url+"/ws/public", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer public.Close()
+	var greeting JsonMessage
+	if err = public.ReadJSON(&greeting); err != nil {
+		t.Fatal(err)
+	}
+	call := func(command string) int32 {
+		if err := public.WriteJSON(JsonMessage{RequestId: command, SocketId: greeting.SocketId, Command: command}); err != nil {
+			t.Fatal(err)
+		}
+		var reply JsonMessage
+		if err := public.ReadJSON(&reply); err != nil {
+			t.Fatal(err)
+		}
+		return reply.Code
+	}
+	if code := call("chat.send"); code != http.StatusOK {
+		t.Fatalf("chat.send replied %d", code)
+	}
+	if code := call("admin.kick"); code != http.StatusForbidden {
+		t.Fatalf("admin.kick on the public endpoint replied %d", code)
+	}
+	raw, _ := proto.Marshal(&ProtoMessage{RequestId: "1", SocketId: greeting.SocketId, Command: "chat.send"})
+	if err = public.WriteMessage(ws.BinaryMessage, raw); err != nil {
+		t.Fatal(err)
+	}
+	var closeErr *ws.CloseError
+	if _, _, err = public.ReadMessage(); !errors.As(err, &closeErr) || closeErr.Code != ws.CloseUnsupportedData {
+		t.Fatalf("binary frame on the JSON endpoint: %v", err)
+	}
+
+	if _, resp, err := ws.DefaultDialer.Dial(url+"/ws/internal", nil); err == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("internal endpoint without token: %v", err)
+	}
+	internal, _, err := ws.DefaultDialer.Dial(url+"/ws/internal", http.Header{"Authorization": {"Bearer internal"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer internal.Close()
+	messageType, data, err := internal.ReadMessage()
+	var protoGreeting ProtoMessage
+	if err != nil || messageType != ws.BinaryMessage || proto.Unmarshal(data, &protoGreeting) != nil {
+		t.Fatalf("internal greeting %d %q %v", messageType, data, err)
+	}
+	raw, _ = proto.Marshal(&ProtoMessage{RequestId: "1", SocketId: protoGreeting.SocketId, Command: "admin.kick"})
+	if err = internal.WriteMessage(ws.BinaryMessage, raw); err != nil {
+		t.Fatal(err)
+	}
+	var reply ProtoMessage
+	if _, data, err = internal.ReadMessage(); err != nil || proto.Unmarshal(data, &reply) != nil || reply.Code != http.StatusOK {
+		t.Fatalf("admin.kick on the internal endpoint: %+v %v", &reply, err)
+	}
+}
+
+func TestEndpointOnConnect(t *testing.T) {
+	var calls []string
+	hook := func(name string, err error) ConnectHook {
+		return func(c *Client, r *http.Request) error {
+			calls = append(calls, name)
+			return err
+		}
+	}
+	e := newTestEngine(WithOnConnect(hook("engine", nil)))
+	defer e.Shutdown(t.Context())
+	mux := http.NewServeMux()
+	mux.Handle("/ws/open", e.Endpoint(WithEndpointOnConnect(hook("first", nil)), WithEndpointOnConnect(hook("second", nil))))
+	mux.Handle("/ws/closed", e.Endpoint(WithEndpointOnConnect(hook("refuse", errors.New("closed"))), WithEndpointOnConnect(hook("never", nil))))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	conn, _, err := ws.DefaultDialer.Dial(url+"/ws/open", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, _, err = conn.ReadMessage(); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(calls, ",") != "engine,first,second" {
+		t.Fatalf("hooks ran %q", calls)
+	}
+
+	calls = nil
+	refused, _, err := ws.DefaultDialer.Dial(url+"/ws/closed", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer refused.Close()
+	var closeErr *ws.CloseError
+	if _, _, err = refused.ReadMessage(); !errors.As(err, &closeErr) || closeErr.Text != "closed" {
+		t.Fatalf("refused connection read %v", err)
+	}
+	if strings.Join(calls, ",") != "engine,refuse" {
+		t.Fatalf("hooks ran %q", calls)
+	}
+}
@@ -1,21 +1,31 @@
 package websocket
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/gin-generator/logger"
+	"github.com/gorilla/websocket"
 	"github.com/panjf2000/ants/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
 	"sync"
 	"sync/atomic"
-	"syscall"
+	"time"
 )
 
 const (
-	RateLimit       = 100
+	PublishWorkPool = 100 // default Publish worker pool size
 	ReadBufferSize  = 1024
 	WriteBufferSize = 1024
+
+	// Deprecated: RateLimit has always been the Publish worker pool size, use PublishWorkPool.
+	RateLimit = PublishWorkPool
 )
 
 type Engine struct {
@@ -23,35 +33,101 @@ type Engine struct {
 	protoRouter *Router[*ProtoMessage]
 
 	pool            sync.Map
-	maxConn         uint32
+	maxConn         uint32 // 0 means unlimited
+	maxConnPerIP    int    // 0 means unlimited
 	total           atomic.Uint32
+	admission       *admission
+	userResolver    UserResolver
+	clientIP        func(r *http.Request) string
+	retryAfter      time.Duration
 	readBufferSize  int
 	writeBufferSize int
+	maxMessageSize  int64
+	maxDataSize     int
+	maxJSONDepth    int
+	maxProtoFields  int
 	workPool        int
 	storage         Memory
-	log             *logger.Logger
+	users           *userIndex
+	userLimit       *limitConfig
+	userBuckets     sync.Map // user id -> *tokenBucket
+	uploads         sync.Map // upload id -> *upload
+	log             *slog.Logger
+	logLevel        slog.Leveler
+	logSampler      *logSampler
+	clock           Clock
+	clientOptions   []Option
+	handlerTimeout  time.Duration
+	endpoint        *endpoint // settings of ServeHTTP
+	wheelTick       time.Duration
+	wheelSlots      int
+	wheel           *timingWheel
+
+	closeMux      sync.Mutex
+	closing       bool
+	routines      sync.WaitGroup // client read/write goroutines
+	onShutdown    []func()
+	reconnectHint string
+	signals       []os.Signal
+	signalTimeout time.Duration
+	hooks         hooks
+	metrics       Metrics
+	tracer        trace.Tracer
+	propagator    propagation.TextMapPropagator
+	stats         engineStats
 }
 
+// ErrEngineClosed is returned by upgrades attempted after Shutdown was called.
+var ErrEngineClosed = errors.New("websocket engine is shutting down")
+
 func newDefaultEngine() *Engine {
 	return &Engine{
 		jsonRouter:      NewRouter[*JsonMessage](),
 		protoRouter:     NewRouter[*ProtoMessage](),
 		readBufferSize:  ReadBufferSize,
 		writeBufferSize: WriteBufferSize,
-		workPool:        RateLimit,
+		maxMessageSize:  MaxMessageSize,
+		maxJSONDepth:    MaxJSONDepth,
+		maxProtoFields:  MaxProtoFields,
+		workPool:        PublishWorkPool,
 		storage:         newSystemMemory(),
-		log:             logger.NewLogger(),
+		users:           newUserIndex(),
+		admission:       newAdmission(),
+		clientIP:        remoteIP,
+		retryAfter:      RetryAfter,
+		metrics:         NopMetrics{},
+		tracer:          noopTracer,
+		propagator:      propagation.TraceContext{},
+		userLimit:       &limitConfig{},
+		log:             slog.New(newZapHandler(logger.NewLogger().Log)),
+		clock:           wallClock{},
+		wheelTick:       WheelTick,
+		wheelSlots:      WheelSlots,
 	}
 }
 
 // RegisterJsonRouter register json route
-func (e *Engine) RegisterJsonRouter(command string, handler Handler[*JsonMessage]) {
-	e.jsonRouter.register(command, handler)
+func (e *Engine) RegisterJsonRouter(command string, handler Handler[*JsonMessage], opts ...RouteOption) {
+	e.jsonRouter.register(command, func(_ context.Context, message *JsonMessage) {
+		handler(message)
+	}, opts...)
 }
 
 // RegisterProtoRouter register proto route
-func (e *Engine) RegisterProtoRouter(command string, handler Handler[*ProtoMessage]) {
-	e.protoRouter.register(command, handler)
+func (e *Engine) RegisterProtoRouter(command string, handler Handler[*ProtoMessage], opts ...RouteOption) {
+	e.protoRouter.register(command, func(_ context.Context, message *ProtoMessage) {
+		handler(message)
+	}, opts...)
+}
+
+// RegisterJsonContextRouter register json route whose handler receives the message context
+func (e *Engine) RegisterJsonContextRouter(command string, handler ContextHandler[*JsonMessage], opts ...RouteOption) {
+	e.jsonRouter.register(command, handler, opts...)
+}
+
+// RegisterProtoContextRouter register proto route whose handler receives the message context
+func (e *Engine) RegisterProtoContextRouter(command string, handler ContextHandler[*ProtoMessage], opts ...RouteOption) {
+	e.protoRouter.register(command, handler, opts...)
 }
 
 // registerClient register client
@@ -99,10 +175,46 @@ func (e *Engine) Unsubscribe(id, channel string) error {
 
 // Publish message to channel
 func (e *Engine) Publish(channel string, message []byte) (err error) {
+	return e.PublishContext(context.Background(), channel, message)
+}
+
+// PublishContext publishes message to channel within the trace of ctx.
+func (e *Engine) PublishContext(ctx context.Context, channel string, message []byte) error {
+	return e.publish(ctx, channel, newRawPayload(message))
+}
+
+// PublishMessage publishes command and data to channel as a message envelope,
+// encoded as JSON for text clients and as proto for binary clients. Each
+// encoding is built once whatever the number of subscribers.
+func (e *Engine) PublishMessage(channel, command string, data []byte) error {
+	return e.PublishMessageContext(context.Background(), channel, command, data)
+}
+
+// PublishMessageContext is PublishMessage within the trace of ctx, the trace
+// context is propagated to subscribers in the envelope metadata.
+func (e *Engine) PublishMessageContext(ctx context.Context, channel, command string, data []byte) error {
+	return e.publish(ctx, channel, newEnvelopePayload(command, data))
+}
+
+// publish fans p out to the subscribers of channel.
+func (e *Engine) publish(ctx context.Context, channel string, p *payload) (err error) {
+	start := time.Now()
+	ctx, span := e.tracer.Start(ctx, "publish "+channel,
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(attribute.String("websocket.channel", channel)),
+	)
+	defer span.End()
+	if p.metadata != nil {
+		p.metadata[MetadataChannel] = channel
+		e.InjectTrace(ctx, p.metadata)
+	}
+
 	ids, err := e.storage.GetSubscribers(channel)
 	if err != nil {
+		spanError(span, err)
 		return
 	}
+	span.SetAttributes(attribute.Int("websocket.fanout", len(ids)))
 
 	pool, poolErr := ants.NewPool(e.workPool)
 	if poolErr != nil {
@@ -121,29 +233,108 @@ func (e *Engine) Publish(channel string, message []byte) (err error) {
 				_ = e.storage.Delete(key, channel)
 				return
 			}
-			client.message <- message
+			_ = client.sendPayload(p, WithPriority(PriorityBroadcast))
 		})
 		if err != nil {
 			wg.Done()
-			e.log.ErrorString("Engine", "Publish error", err.Error())
+			e.log.Error("publish failed", "channel", channel, "err", err)
 		}
 	}
 	wg.Wait()
+	e.metrics.Published(len(ids), time.Since(start))
 	return nil
 }
 
-func (e *Engine) shutdown() {
+// RegisterOnShutdown registers a function to call once all clients are gone during Shutdown.
+func (e *Engine) RegisterOnShutdown(f func()) {
+	e.closeMux.Lock()
+	defer e.closeMux.Unlock()
+	e.onShutdown = append(e.onShutdown, f)
+}
+
+// isClosing reports whether Shutdown has been called.
+func (e *Engine) isClosing() bool {
+	e.closeMux.Lock()
+	defer e.closeMux.Unlock()
+	return e.closing
+}
+
+// track accounts for the client goroutines, it fails once the engine is shutting down.
+func (e *Engine) track(routines int) bool {
+	e.closeMux.Lock()
+	defer e.closeMux.Unlock()
+	if e.closing {
+		return false
+	}
+	e.routines.Add(routines)
+	return true
+}
+
+// Shutdown gracefully shuts the engine down. It stops accepting upgrades, sends
+// every client a going-away close frame carrying the reconnect hint after its
+// queued messages, runs the shutdown hooks and returns once all client
+// goroutines have exited. Clients still connected when ctx is done are closed
+// abruptly and ctx.Err() is returned at once: handlers ignoring their context
+// are left running, only a handler timeout bounds them.
+func (e *Engine) Shutdown(ctx context.Context) (err error) {
+	e.closeMux.Lock()
+	if e.closing {
+		e.closeMux.Unlock()
+		return ErrEngineClosed
+	}
+	e.closing = true
+	hooks := e.onShutdown
+	e.closeMux.Unlock()
+	e.log.Info("shutting down", "connections", e.total.Load())
+
 	e.pool.Range(func(key, value any) bool {
 		if client, ok := value.(*Client); ok {
-			client.release()
+			client.closeWith(e.shutdownReason())
 		}
 		return true
 	})
+
+	done := make(chan struct{})
+	go func() {
+		e.routines.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+		e.pool.Range(func(key, value any) bool {
+			if client, ok := value.(*Client); ok {
+				client.releaseWith(e.shutdownReason())
+			}
+			return true
+		})
+	}
+
+	for _, hook := range hooks {
+		hook()
+	}
+	e.discardUploads()
+	e.wheel.close()
+	return
 }
 
+// shutdownReason is the reason recorded for clients closed by Shutdown.
+func (e *Engine) shutdownReason() DisconnectReason {
+	return DisconnectReason{Kind: DisconnectShutdown, Code: websocket.CloseGoingAway, Text: e.reconnectHint}
+}
+
+// waitForShutdown shuts the engine down when one of the configured signals arrives.
 func (e *Engine) waitForShutdown() {
 	sig := make(chan os.Signal, 1)
-	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(sig, e.signals...)
+	defer signal.Stop(sig)
 	<-sig
-	e.shutdown()
+
+	ctx, cancel := context.WithTimeout(context.Background(), e.signalTimeout)
+	defer cancel()
+	if err := e.Shutdown(ctx); err != nil {
+		e.log.Error("shutdown failed", "err", err)
+	}
 }
@@ -0,0 +1,192 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ws "github.com/gorilla/websocket"
+)
+
+// newTestEngine returns an engine logging nowhere, so tests write no log files.
+func newTestEngine(opts ...EngineOption) *Engine {
+	return NewEngineWithOptions(append([]EngineOption{WithSlog(slog.New(slog.DiscardHandler))}, opts...)...)
+}
+
+// testClock is a clock set by the test, its tickers are real ones so that the
+// timing wheel keeps turning.
+type testClock struct {
+	mux sync.Mutex
+	now time.Time
+}
+
+func newTestClock() *testClock {
+	return &testClock{now: time.Unix(1_700_000_000, 0)}
+}
+
+func (c *testClock) Now() time.Time {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.now
+}
+
+func (c *testClock) NewTicker(d time.Duration) (<-chan time.Time, func()) {
+	ticker := time.NewTicker(d)
+	return ticker.C, ticker.Stop
+}
+
+func (c *testClock) advance(d time.Duration) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// serveEngine serves e and returns the websocket url of the server and the
+// clients of the upgraded connections, in order.
+func serveEngine(t *testing.T, e *Engine) (string, <-chan *Client) {
+	clients := make(chan *Client, 16)
+	e.hooks.onConnect = append(e.hooks.onConnect, func(c *Client, r *http.Request) error {
+		clients <- c
+		return nil
+	})
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http"), clients
+}
+
+func TestShutdown(t *testing.T) {
+	e := newTestEngine(WithReconnectHint("try later"))
+	url, clients := serveEngine(t, e)
+	conn, _, err := ws.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := <-clients
+
+	remaining := make(chan uint32, 1)
+	e.RegisterOnShutdown(func() {
+		remaining <- e.Stats().Connections
+	})
+	for i := range 3 {
+		if err = client.Send([]byte(strconv.Itoa(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- e.Shutdown(context.Background())
+	}()
+
+	var received []string
+	for {
+		_, data, err := conn.ReadMessage()
+		var closeErr *ws.CloseError
+		if errors.As(err, &closeErr) {
+			if closeErr.Code != ws.CloseGoingAway || closeErr.Text != "try later" {
+				t.Fatalf("closed with %d %q", closeErr.Code, closeErr.Text)
+			}
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(data), Connected) {
+			received = append(received, string(data))
+		}
+	}
+	if strings.Join(received, ",") != "0,1,2" {
+		t.Fatalf("received %q before the close frame", received)
+	}
+
+	if err = <-shutdown; err != nil {
+		t.Fatal(err)
+	}
+	if n := <-remaining; n != 0 {
+		t.Fatalf("shutdown hook ran with %d connections left", n)
+	}
+	if kind := client.DisconnectReason().Kind; kind != DisconnectShutdown {
+		t.Fatalf("client disconnected by %s", kind)
+	}
+	if err = e.Shutdown(context.Background()); !errors.Is(err, ErrEngineClosed) {
+		t.Fatalf("second shutdown returned %v", err)
+	}
+	if _, resp, err := ws.DefaultDialer.Dial(url, nil); err == nil || resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("upgrade after shutdown: %v %v", resp, err)
+	}
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	e := newTestEngine()
+	url, clients := serveEngine(t, e)
+	conn, _, err := ws.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := <-clients
+
+	// the peer reads nothing, so the write loop blocks once the socket buffers are full
+	payload := make([]byte, 256<<10)
+	for range SendLimit {
+		_ = client.Send(payload)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err = e.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("shutdown returned %v", err)
+	}
+	if elapsed := time.Since(start); elapsed >= WriteWait {
+		t.Fatalf("shutdown took %s", elapsed)
+	}
+	if kind := client.DisconnectReason().Kind; kind != DisconnectShutdown {
+		t.Fatalf("client disconnected by %s", kind)
+	}
+	if n := e.Stats().Connections; n != 0 {
+		t.Fatalf("%d connections left", n)
+	}
+}
+
+func TestShutdownDeadlineHungHandler(t *testing.T) {
+	e := newTestEngine()
+	hung, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	e.RegisterJsonRouter("hang", func(m *JsonMessage) {
+		close(hung)
+		<-release
+	})
+	url, clients := serveEngine(t, e)
+	conn, _, err := ws.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := <-clients
+	if err = conn.WriteJSON(&JsonMessage{RequestId: "1", SocketId: client.ID(), Command: "hang"}); err != nil {
+		t.Fatal(err)
+	}
+	<-hung
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- e.Shutdown(ctx)
+	}()
+	select {
+	case err = <-shutdown:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("shutdown returned %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("shutdown waited for a hung handler past its deadline")
+	}
+}
@@ -3,7 +3,9 @@ package main
 import (
 	"fmt"
 	"github.com/gin-generator/websocket"
+	"github.com/gin-generator/websocket/ginws"
 	"github.com/gin-gonic/gin"
+	"time"
 )
 
 func main() {
@@ -12,19 +14,16 @@ func main() {
 	r := gin.New()
 	r.Use(gin.Recovery())
 
-	engine := websocket.NewEngineWithOptions(
+	engine := newEngine(
 		websocket.WithMaxConn(100),
 		websocket.WithReadBufferSize(1024),
 		websocket.WithWriteBufferSize(1024),
+		websocket.WithSignalShutdown(10*time.Second), // Drain clients on SIGINT/SIGTERM
 		// websocket.WithSubscribeEngine(newRedisManager()), // use your own redis manager
 	)
 
-	// register external trigger route
-	engine.RegisterJsonRouter("ping", TextPing)
-	engine.RegisterProtoRouter("ping", ProtoPing)
-
 	// upgrade websocket router
-	r.GET("/ws", websocket.Connect(
+	r.GET("/ws", ginws.Connect(
 		engine,
 		websocket.WithSendLimit(1000), // Set the sending frequency
 		websocket.WithBreakTime(60),   // Set the timeout disconnection time.
@@ -39,6 +38,17 @@ func main() {
 	}
 }
 
+// newEngine creates the engine and registers its routes, the tests serve it with wstest.
+func newEngine(opts ...websocket.EngineOption) *websocket.Engine {
+	engine := websocket.NewEngineWithOptions(opts...)
+
+	// register external trigger route
+	engine.RegisterJsonRouter("ping", TextPing)
+	engine.RegisterProtoRouter("ping", ProtoPing)
+	engine.RegisterSubscribeRouter() // subscribe/unsubscribe commands used by the client package
+	return engine
+}
+
 func newRedisManager() websocket.Memory {
 	return &RedisManager{}
 }
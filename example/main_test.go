@@ -2,70 +2,41 @@ package main
 
 import (
 	"github.com/gin-generator/websocket"
+	"github.com/gin-generator/websocket/client"
+	"github.com/gin-generator/websocket/wstest"
 	ws "github.com/gorilla/websocket"
-	"google.golang.org/protobuf/proto"
+	"log/slog"
+	"net/http"
 	"testing"
-	"time"
 )
 
 func TestProtoPing(t *testing.T) {
-	wsURL := "ws://127.0.0.1:9503/ws"
-	wsConn, _, err := ws.DefaultDialer.Dial(wsURL, nil)
-	if err != nil {
-		t.Fatalf("WebSocket connection failed: %v", err)
-	}
-	defer wsConn.Close()
+	server := wstest.NewServer(t, newEngine(websocket.WithSlog(slog.New(slog.DiscardHandler))))
+	conn := server.Dial(t, wstest.WithCodec(client.Proto))
 
-	req := &websocket.ProtoMessage{
-		RequestId: "req-123",
-		SocketId:  "socket-456",
-		Command:   "ping",
+	res := conn.Call("ping", nil)
+	t.Logf("Received ProtoMessage: Code=%d, Message=%s", res.Code, res.Message)
+	if res.Code != http.StatusOK || res.Message != "pong" {
+		t.Fatalf("unexpected reply: %+v", res)
 	}
+}
 
-	raw, err := proto.Marshal(req)
-	if err != nil {
-		t.Fatalf("protobuf marshal failed: %v", err)
-	}
+func TestTextPing(t *testing.T) {
+	server := wstest.NewServer(t, newEngine(websocket.WithSlog(slog.New(slog.DiscardHandler))))
+	conn := server.Dial(t)
 
-	err = wsConn.WriteMessage(ws.BinaryMessage, raw)
-	if err != nil {
-		t.Fatalf("send failed: %v", err)
+	res := conn.Call("ping", nil)
+	if res.Code != http.StatusOK || res.Message != "pong" {
+		t.Fatalf("unexpected reply: %+v", res)
 	}
+}
 
-	done := make(chan struct{})
-	go func() {
-		defer close(done)
-		for {
-			_ = wsConn.SetReadDeadline(time.Now().Add(5 * time.Second))
-
-			_, msg, errs := wsConn.ReadMessage()
-			if errs != nil {
-				if ws.IsUnexpectedCloseError(errs, ws.CloseNormalClosure) {
-					t.Logf("read error: %v", errs)
-				}
-				return
-			}
-
-			var res websocket.ProtoMessage
-			errs = proto.Unmarshal(msg, &res)
-			if errs != nil {
-				t.Logf("unmarshal error: %v", errs)
-				continue
-			}
-
-			t.Logf("Received ProtoMessage: Code=%d, Message=%s", res.Code, res.Message)
-
-			// Stop when pong response is received
-			if res.Message == "pong" {
-				return
-			}
-		}
-	}()
+func TestPingHandler(t *testing.T) {
+	engine := newEngine(websocket.WithSlog(slog.New(slog.DiscardHandler)))
+	defer engine.Shutdown(t.Context())
 
-	select {
-	case <-done:
-		t.Log("Test finished gracefully")
-	case <-time.After(5 * time.Second):
-		t.Error("Test timeout: no response received")
+	res := wstest.Invoke(t, engine, "ping", nil, websocket.WithProtocol(ws.BinaryMessage))
+	if res.Message != "pong" {
+		t.Fatalf("unexpected reply: %+v", res)
 	}
 }
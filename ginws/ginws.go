@@ -0,0 +1,34 @@
+// Package ginws adapts the websocket engine to gin. Connect and
+// ConnectEndpoint replace websocket.Connect and websocket.ConnectEndpoint,
+// removed when the root package dropped its gin dependency.
+package ginws
+
+import (
+	"github.com/gin-generator/websocket"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// Connect returns a gin handler upgrading requests to connections of engine
+// with the client options opts, see Engine.Handler.
+func Connect(engine *websocket.Engine, opts ...websocket.Option) gin.HandlerFunc {
+	return gin.WrapH(engine.Handler(opts...))
+}
+
+// ConnectEndpoint returns a gin handler upgrading requests with the endpoint
+// settings opts, see Engine.Endpoint.
+func ConnectEndpoint(engine *websocket.Engine, opts ...websocket.EndpointOption) gin.HandlerFunc {
+	return gin.WrapH(engine.Endpoint(opts...))
+}
+
+// Admin registers the introspection API of engine on group, see Engine.Admin.
+func Admin(engine *websocket.Engine, group *gin.RouterGroup, auth websocket.AdminAuth) {
+	handler := http.StripPrefix(group.BasePath(), engine.Admin(auth))
+	group.Any("/*path", gin.WrapH(handler))
+}
+
+// Metrics returns a gin handler serving metrics, e.g.
+// router.GET("/metrics", ginws.Metrics(metrics)).
+func Metrics(metrics *websocket.PrometheusMetrics) gin.HandlerFunc {
+	return gin.WrapH(metrics)
+}
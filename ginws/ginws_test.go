@@ -0,0 +1,87 @@
+package ginws
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-generator/websocket"
+	"github.com/gin-gonic/gin"
+	ws "github.com/gorilla/websocket"
+)
+
+func TestGin(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	metrics := websocket.NewPrometheusMetrics("")
+	engine := websocket.NewEngineWithOptions(websocket.WithSlog(slog.New(slog.DiscardHandler)), websocket.WithMetrics(metrics))
+	defer engine.Shutdown(t.Context())
+	engine.RegisterJsonRouter("ping", func(m *websocket.JsonMessage) {
+		m.Code, m.Message = http.StatusOK, "pong"
+	})
+
+	r := gin.New()
+	r.GET("/ws", Connect(engine))
+	r.GET("/internal", ConnectEndpoint(engine, websocket.WithAllowedCommands("admin.*")))
+	r.GET("/metrics", Metrics(metrics))
+	Admin(engine, r.Group("/admin"), func(r *http.Request) error {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			return errors.New("bad token")
+		}
+		return nil
+	})
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	ping := func(path string) websocket.JsonMessage {
+		conn, _, err := ws.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		var greeting, reply websocket.JsonMessage
+		if err = conn.ReadJSON(&greeting); err != nil {
+			t.Fatal(err)
+		}
+		if err = conn.WriteJSON(websocket.JsonMessage{RequestId: "1", SocketId: greeting.SocketId, Command: "ping"}); err != nil {
+			t.Fatal(err)
+		}
+		if err = conn.ReadJSON(&reply); err != nil {
+			t.Fatal(err)
+		}
+		return reply
+	}
+	if reply := ping("/ws"); reply.Message != "pong" {
+		t.Fatalf("reply %+v", reply)
+	}
+	if reply := ping("/internal"); reply.Code != http.StatusForbidden {
+		t.Fatalf("endpoint reply %+v", reply)
+	}
+
+	get := func(path, token string) (int, string) {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+	if status, _ := get("/admin/stats", "wrong"); status != http.StatusUnauthorized {
+		t.Fatalf("unauthorized stats = %d", status)
+	}
+	status, body := get("/admin/stats", "secret")
+	var stats websocket.Stats
+	if status != http.StatusOK || json.Unmarshal([]byte(body), &stats) != nil {
+		t.Fatalf("stats = %d %s", status, body)
+	}
+	if status, body = get("/metrics", ""); status != http.StatusOK || !strings.Contains(body, "websocket_connections_opened_total 2") {
+		t.Fatalf("metrics = %d %s", status, body)
+	}
+}
@@ -0,0 +1,66 @@
+package websocket
+
+import (
+	"net/http"
+	"time"
+)
+
+type (
+	// ConnectHook runs after the upgrade, before the client is registered and
+	// greeted. It may decorate the client (values, user); returning an error
+	// rejects the client with a policy violation close frame.
+	ConnectHook func(client *Client, r *http.Request) error
+	// DisconnectHook runs once a client has been released, duration is the
+	// session length measured from firstTime on the engine clock.
+	DisconnectHook func(client *Client, reason DisconnectReason, duration time.Duration)
+	// FrameHook observes a raw data frame read from or written to a client.
+	FrameHook func(client *Client, messageType int, data []byte)
+	// ErrorHook observes errors replied to a client, including handler panics.
+	ErrorHook func(client *Client, err error, code int32)
+)
+
+// hooks are the engine level extension points, each kind runs in registration order.
+type hooks struct {
+	onConnect    []ConnectHook
+	onDisconnect []DisconnectHook
+	onMessage    []FrameHook
+	onSend       []FrameHook
+	onError      []ErrorHook
+}
+
+func (h *hooks) connect(client *Client, r *http.Request) error {
+	for _, hook := range h.onConnect {
+		if err := hook(client, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *hooks) disconnect(client *Client, reason DisconnectReason) {
+	if len(h.onDisconnect) == 0 {
+		return
+	}
+	duration := client.engine.clock.Now().Sub(client.ConnectedAt())
+	for _, hook := range h.onDisconnect {
+		hook(client, reason, duration)
+	}
+}
+
+func (h *hooks) message(client *Client, messageType int, data []byte) {
+	for _, hook := range h.onMessage {
+		hook(client, messageType, data)
+	}
+}
+
+func (h *hooks) send(client *Client, messageType int, data []byte) {
+	for _, hook := range h.onSend {
+		hook(client, messageType, data)
+	}
+}
+
+func (h *hooks) error(client *Client, err error, code int32) {
+	for _, hook := range h.onError {
+		hook(client, err, code)
+	}
+}
@@ -0,0 +1,152 @@
+package websocket
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ws "github.com/gorilla/websocket"
+)
+
+func TestConnectHookRejects(t *testing.T) {
+	e := newTestEngine(WithOnConnect(func(c *Client, r *http.Request) error {
+		if r.URL.Query().Get("token") == "" {
+			return errors.New("token required")
+		}
+		return nil
+	}))
+	defer e.Shutdown(t.Context())
+	url, clients := serveEngine(t, e)
+
+	conn, _, err := ws.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_, _, err = conn.ReadMessage()
+	var closeErr *ws.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != ws.ClosePolicyViolation || closeErr.Text != "token required" {
+		t.Fatalf("rejected connection read %v", err)
+	}
+	if n := e.Stats().Connections; n != 0 {
+		t.Fatalf("%d connections registered", n)
+	}
+
+	accepted, _, err := ws.DefaultDialer.Dial(url+"?token=t", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer accepted.Close()
+	<-clients
+}
+
+func TestDisconnectHook(t *testing.T) {
+	clock := newTestClock()
+	type disconnect struct {
+		reason   DisconnectReason
+		duration time.Duration
+	}
+	disconnects := make(chan disconnect, 1)
+	e := newTestEngine(WithClock(clock), WithOnDisconnect(func(c *Client, reason DisconnectReason, duration time.Duration) {
+		disconnects <- disconnect{reason, duration}
+	}))
+	defer e.Shutdown(t.Context())
+
+	c := e.NewSyntheticClient()
+	clock.advance(90 * time.Second)
+	if err := e.Kick(c.ID(), 4000, "bye"); err != nil {
+		t.Fatal(err)
+	}
+	d := <-disconnects
+	if d.reason.Kind != DisconnectKick || d.reason.Code != 4000 || d.reason.Text != "bye" || d.duration != 90*time.Second {
+		t.Fatalf("disconnect hook got %s after %s", d.reason, d.duration)
+	}
+}
+
+func TestFrameHooks(t *testing.T) {
+	var (
+		mux      sync.Mutex
+		received []string
+		sent     = make(chan string, 4)
+	)
+	e := newTestEngine(
+		WithOnMessage(func(c *Client, messageType int, data []byte) {
+			mux.Lock()
+			defer mux.Unlock()
+			received = append(received, string(data))
+		}),
+		WithOnSend(func(c *Client, messageType int, data []byte) {
+			sent <- string(data)
+		}),
+	)
+	defer e.Shutdown(t.Context())
+	e.RegisterJsonRouter("echo", func(m *JsonMessage) {
+		m.Code = http.StatusOK
+	})
+	url, clients := serveEngine(t, e)
+
+	conn, _, err := ws.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := <-clients
+	if greeting := <-sent; !strings.Contains(greeting, Connected) {
+		t.Fatalf("first sent frame %q", greeting)
+	}
+
+	request := `{"request_id":"1","socket_id":"` + client.ID() + `","command":"echo","data":"aGk="}`
+	if err = conn.WriteMessage(ws.TextMessage, []byte(request)); err != nil {
+		t.Fatal(err)
+	}
+	_, reply, err := conn.ReadMessage() // the greeting
+	if err == nil {
+		_, reply, err = conn.ReadMessage()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hooked := <-sent; hooked != string(reply) {
+		t.Fatalf("send hook saw %q, peer read %q", hooked, reply)
+	}
+	mux.Lock()
+	defer mux.Unlock()
+	if len(received) != 1 || received[0] != request {
+		t.Fatalf("message hook saw %q", received)
+	}
+}
+
+func TestErrorHook(t *testing.T) {
+	type failure struct {
+		err  error
+		code int32
+	}
+	failures := make(chan failure, 4)
+	e := newTestEngine(WithOnError(func(c *Client, err error, code int32) {
+		failures <- failure{err, code}
+	}))
+	defer e.Shutdown(t.Context())
+	e.RegisterJsonRouter("panic", func(m *JsonMessage) {
+		panic("boom")
+	})
+
+	c := e.NewSyntheticClient()
+	c.Dispatch(ws.TextMessage, []byte(`{"request_id":"1","socket_id":"`+c.ID()+`","command":"missing"}`))
+	if f := <-failures; f.code != http.StatusBadRequest {
+		t.Fatalf("unknown command hooked %v %d", f.err, f.code)
+	}
+
+	c.Dispatch(ws.TextMessage, []byte(`{"request_id":"2","socket_id":"`+c.ID()+`","command":"panic"}`))
+	if f := <-failures; f.code != http.StatusInternalServerError || !errors.Is(f.err, ErrHandlerPanic) ||
+		!strings.Contains(f.err.Error(), "boom") {
+		t.Fatalf("panic hooked %v %d", f.err, f.code)
+	}
+	replies := c.Drain()
+	if len(replies) != 2 || !strings.Contains(string(replies[1]), `"request_id":"2"`) ||
+		!strings.Contains(string(replies[1]), `"code":500`) {
+		t.Fatalf("replies %q", replies)
+	}
+}
@@ -0,0 +1,85 @@
+package websocket
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	MaxMessageSize = 4 << 20 // default inbound frame size limit in bytes
+	MaxJSONDepth   = 32      // default JSON nesting depth limit
+	MaxProtoFields = 64      // default proto envelope field count limit
+)
+
+var (
+	ErrMessageTooBig = errors.New("message too big")
+	ErrDataTooBig    = errors.New("data too big")
+)
+
+// checkJSONDepth rejects JSON documents nested deeper than limit, 0 means no limit.
+func checkJSONDepth(data []byte, limit int) error {
+	if limit <= 0 {
+		return nil
+	}
+	depth, inString, escaped := 0, false, false
+	for _, b := range data {
+		switch {
+		case escaped:
+			escaped = false
+		case inString && b == '\\':
+			escaped = true
+		case b == '"':
+			inString = !inString
+		case inString:
+		case b == '{' || b == '[':
+			if depth++; depth > limit {
+				return fmt.Errorf("json nesting exceeds depth %d", limit)
+			}
+		case b == '}' || b == ']':
+			depth--
+		}
+	}
+	return nil
+}
+
+// checkProtoFields rejects proto messages carrying more than limit fields, 0 means no limit.
+func checkProtoFields(data []byte, limit int) error {
+	if limit <= 0 {
+		return nil
+	}
+	for fields := 0; len(data) > 0; fields++ {
+		if fields >= limit {
+			return fmt.Errorf("proto message exceeds %d fields", limit)
+		}
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+		n = protowire.ConsumeFieldValue(num, typ, data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+// checkSize enforces the engine and command limits on the raw message and its
+// Data field. Oversized messages close the client with CloseMessageTooBig.
+func (c *Client) checkSize(message, data []byte, route *routeConfig) (err error) {
+	switch {
+	case route != nil && route.maxSize > 0 && len(message) > route.maxSize:
+		err = ErrMessageTooBig
+	case c.engine.maxDataSize > 0 && len(data) > c.engine.maxDataSize,
+		route != nil && route.maxDataSize > 0 && len(data) > route.maxDataSize:
+		err = ErrDataTooBig
+	default:
+		return nil
+	}
+	c.engine.stats.oversized.Add(1)
+	c.closeWith(DisconnectReason{Kind: DisconnectMessageTooBig, Code: websocket.CloseMessageTooBig, Text: err.Error(), Err: err})
+	return
+}
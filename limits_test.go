@@ -0,0 +1,169 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	ws "github.com/gorilla/websocket"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestCheckJSONDepth(t *testing.T) {
+	tests := []struct {
+		data  string
+		limit int
+		ok    bool
+	}{
+		{`{"a":{"b":1}}`, 2, true},
+		{`{"a":{"b":[1]}}`, 2, false},
+		{`{"a":"[[[[{{{{"}`, 1, true},
+		{`{"a":"\"[[["}`, 1, true},
+		{`[[[[]]]]`, 0, true},
+	}
+	for _, tt := range tests {
+		if err := checkJSONDepth([]byte(tt.data), tt.limit); (err == nil) != tt.ok {
+			t.Errorf("checkJSONDepth(%s, %d) = %v, want ok %v", tt.data, tt.limit, err, tt.ok)
+		}
+	}
+}
+
+func TestCheckProtoFields(t *testing.T) {
+	var data []byte
+	for i := 0; i < 5; i++ {
+		data = protowire.AppendTag(data, 100, protowire.VarintType)
+		data = protowire.AppendVarint(data, 1)
+	}
+	if err := checkProtoFields(data, 5); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkProtoFields(data, 4); err == nil {
+		t.Fatal("expected too many fields")
+	}
+	if err := checkProtoFields(data[:len(data)-1], 10); err == nil {
+		t.Fatal("expected a parse error")
+	}
+}
+
+// closedWith sends message on a new connection to url and returns the code it
+// is closed with.
+func closedWith(t *testing.T, url string, messageType int, message []byte) int {
+	t.Helper()
+	conn, _, err := ws.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err = conn.WriteMessage(messageType, message); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		if _, _, err = conn.ReadMessage(); err != nil {
+			var closeErr *ws.CloseError
+			if !errors.As(err, &closeErr) {
+				t.Fatalf("connection failed with %v", err)
+			}
+			return closeErr.Code
+		}
+	}
+}
+
+func TestSizeLimits(t *testing.T) {
+	e := newTestEngine(WithMaxMessageSize(1024), WithMaxDataSize(32))
+	defer e.Shutdown(t.Context())
+	e.RegisterJsonRouter("echo", func(m *JsonMessage) {
+		m.Code = http.StatusOK
+	})
+	e.RegisterJsonRouter("small", func(m *JsonMessage) {
+		m.Code = http.StatusOK
+	}, WithCommandMaxSize(64))
+	e.RegisterJsonRouter("tiny", func(m *JsonMessage) {
+		m.Code = http.StatusOK
+	}, WithCommandMaxDataSize(8))
+	url, _ := serveEngine(t, e)
+	endpoint := httptest.NewServer(e.Endpoint(WithEndpointMaxMessageSize(128)))
+	defer endpoint.Close()
+
+	message := func(command string, data, padding int) []byte {
+		raw, _ := json.Marshal(&JsonMessage{RequestId: "r" + strings.Repeat("r", padding), SocketId: "s", Command: command,
+			Data: make([]byte, data)})
+		return raw
+	}
+	tests := []struct {
+		name    string
+		url     string
+		message []byte
+	}{
+		{"engine frame limit", url, message("echo", 0, 2048)},
+		{"endpoint frame limit", "ws" + strings.TrimPrefix(endpoint.URL, "http"), message("echo", 0, 256)},
+		{"engine data limit", url, message("echo", 64, 0)},
+		{"command size limit", url, message("small", 0, 100)},
+		{"command data limit", url, message("tiny", 16, 0)},
+	}
+	for i, tt := range tests {
+		if code := closedWith(t, tt.url, ws.TextMessage, tt.message); code != ws.CloseMessageTooBig {
+			t.Fatalf("%s: closed with %d", tt.name, code)
+		}
+		if n := e.Stats().Oversized; n != uint64(i+1) {
+			t.Fatalf("%s: %d messages counted as oversized", tt.name, n)
+		}
+	}
+
+	conn, _, err := ws.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for _, raw := range [][]byte{message("echo", 32, 0), message("small", 0, 0), message("tiny", 8, 0)} {
+		if err = conn.WriteMessage(ws.TextMessage, raw); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for range 4 { // greeting and three replies
+		if _, _, err = conn.ReadMessage(); err != nil {
+			t.Fatalf("messages within the limits: %v", err)
+		}
+	}
+	if n := e.Stats().Oversized; n != uint64(len(tests)) {
+		t.Fatalf("messages within the limits counted as oversized, %d", n)
+	}
+}
+
+func TestInvalidMessages(t *testing.T) {
+	e := newTestEngine(WithMaxJSONDepth(2), WithMaxProtoFields(4))
+	defer e.Shutdown(t.Context())
+	url, _ := serveEngine(t, e)
+	conn, _, err := ws.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var fields []byte
+	for range 5 {
+		fields = protowire.AppendTag(fields, 100, protowire.VarintType)
+		fields = protowire.AppendVarint(fields, 1)
+	}
+	for messageType, message := range map[int][]byte{ws.TextMessage: []byte(`{"command":"echo","data":[[[1]]]}`), ws.BinaryMessage: fields} {
+		if err = conn.WriteMessage(messageType, message); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for deadline := time.Now().Add(5 * time.Second); e.Stats().Invalid < 2 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	if n := e.Stats().Invalid; n != 2 {
+		t.Fatalf("%d messages counted as invalid", n)
+	}
+	if n := e.Stats().Oversized; n != 0 {
+		t.Fatalf("%d invalid messages counted as oversized", n)
+	}
+	if n := e.Stats().Connections; n != 1 {
+		t.Fatal("invalid messages closed the connection")
+	}
+}
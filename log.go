@@ -0,0 +1,204 @@
+package websocket
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"log/slog"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// zapHandler is a slog.Handler writing to a zap logger, it keeps the
+// gin-generator/logger output when the engine is given one.
+type zapHandler struct {
+	log    *zap.Logger
+	fields []zap.Field
+	group  string // key prefix of the attributes
+}
+
+func newZapHandler(log *zap.Logger) *zapHandler {
+	return &zapHandler{log: log}
+}
+
+func (h *zapHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return h.log.Core().Enabled(zapLevel(level))
+}
+
+func (h *zapHandler) Handle(_ context.Context, record slog.Record) error {
+	entry := h.log.Check(zapLevel(record.Level), record.Message)
+	if entry == nil {
+		return nil
+	}
+	if record.PC != 0 { // report the slog caller rather than the handler
+		frame, _ := runtime.CallersFrames([]uintptr{record.PC}).Next()
+		entry.Caller = zapcore.NewEntryCaller(frame.PC, frame.File, frame.Line, true)
+	}
+	fields := append(make([]zap.Field, 0, len(h.fields)+record.NumAttrs()), h.fields...)
+	record.Attrs(func(attr slog.Attr) bool {
+		fields = appendZapFields(fields, h.group, attr)
+		return true
+	})
+	entry.Write(fields...)
+	return nil
+}
+
+func (h *zapHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	fields := append([]zap.Field(nil), h.fields...)
+	for _, attr := range attrs {
+		fields = appendZapFields(fields, h.group, attr)
+	}
+	return &zapHandler{log: h.log, fields: fields, group: h.group}
+}
+
+func (h *zapHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &zapHandler{log: h.log, fields: h.fields, group: h.group + name + "."}
+}
+
+// appendZapFields flattens attr into fields, group members get dotted keys.
+func appendZapFields(fields []zap.Field, prefix string, attr slog.Attr) []zap.Field {
+	value := attr.Value.Resolve()
+	if value.Kind() == slog.KindGroup {
+		if attr.Key != "" {
+			prefix += attr.Key + "."
+		}
+		for _, member := range value.Group() {
+			fields = appendZapFields(fields, prefix, member)
+		}
+		return fields
+	}
+	if attr.Key == "" {
+		return fields
+	}
+	return append(fields, zap.Any(prefix+attr.Key, value.Any()))
+}
+
+func zapLevel(level slog.Level) zapcore.Level {
+	switch {
+	case level >= slog.LevelError:
+		return zapcore.ErrorLevel
+	case level >= slog.LevelWarn:
+		return zapcore.WarnLevel
+	case level >= slog.LevelInfo:
+		return zapcore.InfoLevel
+	default:
+		return zapcore.DebugLevel
+	}
+}
+
+// levelHandler drops records below its level before they reach the wrapped handler.
+type levelHandler struct {
+	level slog.Leveler
+	slog.Handler
+}
+
+func (h *levelHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level.Level() && h.Handler.Enabled(ctx, level)
+}
+
+func (h *levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &levelHandler{level: h.level, Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *levelHandler) WithGroup(name string) slog.Handler {
+	return &levelHandler{level: h.level, Handler: h.Handler.WithGroup(name)}
+}
+
+// clientHandler adds the connection fields to every record of a client
+// logger. They are read when the record is written, so a user bound or a
+// protocol switched later shows up in the following records.
+type clientHandler struct {
+	client *Client
+	slog.Handler
+}
+
+func (h *clientHandler) Handle(ctx context.Context, record slog.Record) error {
+	c := h.client
+	record.AddAttrs(
+		slog.String("socket_id", c.id),
+		slog.String("remote_addr", c.RemoteAddr().String()),
+		slog.String("protocol", codec(c.Protocol())),
+	)
+	if userID := c.UserID(); userID != "" {
+		record.AddAttrs(slog.String("user_id", userID))
+	}
+	if c.endpoint != nil && c.endpoint.name != "" {
+		record.AddAttrs(slog.String("endpoint", c.endpoint.name))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h *clientHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &clientHandler{client: h.client, Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *clientHandler) WithGroup(name string) slog.Handler {
+	return &clientHandler{client: h.client, Handler: h.Handler.WithGroup(name)}
+}
+
+// logSampler limits the per-message debug logs: in every tick each message is
+// logged the first times, then once every thereafter times.
+type logSampler struct {
+	tick       time.Duration
+	first      uint64
+	thereafter uint64
+	counts     sync.Map // message -> *sampleCount
+}
+
+type sampleCount struct {
+	window atomic.Int64 // start of the current tick, unix nanoseconds
+	n      atomic.Uint64
+}
+
+// allow reports whether a record of message should be logged, a nil sampler logs everything.
+func (s *logSampler) allow(message string) bool {
+	if s == nil {
+		return true
+	}
+	value, ok := s.counts.Load(message)
+	if !ok {
+		value, _ = s.counts.LoadOrStore(message, new(sampleCount))
+	}
+	count := value.(*sampleCount)
+
+	now := time.Now().UnixNano()
+	window := count.window.Load()
+	if now-window >= int64(s.tick) && count.window.CompareAndSwap(window, now) {
+		count.n.Store(0)
+	}
+	n := count.n.Add(1)
+	if n <= s.first {
+		return true
+	}
+	return s.thereafter > 0 && (n-s.first)%s.thereafter == 0
+}
+
+// Logger returns the engine logger.
+func (e *Engine) Logger() *slog.Logger {
+	return e.log
+}
+
+// Logger returns the connection logger, its records carry the socket id,
+// remote address, protocol and user id of the connection.
+func (c *Client) Logger() *slog.Logger {
+	return c.log
+}
+
+// logSampled writes a high-volume record, e.g. one per message, through the
+// sampler set by WithLogSampling.
+func (e *Engine) logSampled(log *slog.Logger, level slog.Level, message string, args ...any) {
+	ctx := context.Background()
+	if !log.Enabled(ctx, level) || !e.logSampler.allow(message) {
+		return
+	}
+	var pcs [1]uintptr
+	runtime.Callers(2, pcs[:]) // skip Callers and logSampled
+	record := slog.NewRecord(time.Now(), level, message, pcs[0])
+	record.Add(args...)
+	_ = log.Handler().Handle(ctx, record)
+}
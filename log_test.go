@@ -0,0 +1,137 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ws "github.com/gorilla/websocket"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// syncBuffer is a bytes.Buffer safe for the concurrent writes of the engine loops.
+type syncBuffer struct {
+	mux sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return b.buf.Write(p)
+}
+
+// records decodes the JSON log lines written so far.
+func (b *syncBuffer) records(t *testing.T) []map[string]any {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(b.buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("bad log line %q: %v", line, err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestClientLoggerFields(t *testing.T) {
+	out := new(syncBuffer)
+	e := NewEngineWithOptions(
+		WithSlog(slog.New(slog.NewJSONHandler(out, &slog.HandlerOptions{Level: slog.LevelDebug}))),
+		WithOnConnect(func(c *Client, r *http.Request) error {
+			c.SetUser("alice")
+			return nil
+		}),
+	)
+	url, stop := dialAdmission(t, e)
+	defer stop()
+
+	conn, _, err := ws.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, _ = conn.ReadMessage()
+	_ = conn.WriteJSON(JsonMessage{RequestId: "r1", SocketId: "s", Command: "missing"})
+	_, _, _ = conn.ReadMessage()
+	_ = conn.WriteMessage(ws.CloseMessage, ws.FormatCloseMessage(ws.CloseNormalClosure, ""))
+	_ = conn.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	seen := map[string]map[string]any{}
+	for _, record := range out.records(t) {
+		seen[record["msg"].(string)] = record
+	}
+	for _, msg := range []string{"connected", "unknown command", "disconnected"} {
+		record, ok := seen[msg]
+		if !ok {
+			t.Fatalf("missing %q record in %v", msg, seen)
+		}
+		if record["socket_id"] == "" || record["user_id"] != "alice" || record["protocol"] != "json" || record["remote_addr"] == nil {
+			t.Errorf("%q record lacks connection fields: %v", msg, record)
+		}
+	}
+	if seen["disconnected"]["reason"] != "client_close" {
+		t.Errorf("disconnect reason = %v", seen["disconnected"]["reason"])
+	}
+}
+
+func TestLogLevelAndSampling(t *testing.T) {
+	out := new(syncBuffer)
+	e := NewEngineWithOptions(
+		WithSlog(slog.New(slog.NewJSONHandler(out, &slog.HandlerOptions{Level: slog.LevelDebug}))),
+		WithLogLevel(slog.LevelInfo),
+		WithLogSampling(time.Hour, 2, 3),
+	)
+	e.log.Debug("dropped")
+	for i := 0; i < 10; i++ {
+		e.logSampled(e.log, slog.LevelInfo, "sampled", "i", i)
+	}
+	var got []float64
+	for _, record := range out.records(t) {
+		if record["msg"] == "dropped" {
+			t.Error("debug record passed the info level")
+		}
+		if record["msg"] == "sampled" {
+			got = append(got, record["i"].(float64))
+		}
+	}
+	// first 2, then every 3rd of the rest
+	if want := []float64{0, 1, 4, 7}; len(got) != len(want) || got[2] != want[2] || got[3] != want[3] {
+		t.Errorf("sampled %v, want %v", got, want)
+	}
+}
+
+func TestZapHandler(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	log := slog.New(newZapHandler(zap.New(core))).With("socket_id", "s1").WithGroup("req")
+	log.Debug("dropped")
+	log.Warn("slow", "n", 3, slog.Group("q", "len", 7))
+
+	entries := logs.All()
+	if len(entries) != 1 {
+		t.Fatalf("got %d entries, want 1", len(entries))
+	}
+	entry := entries[0]
+	fields := entry.ContextMap()
+	if entry.Level != zapcore.WarnLevel || entry.Message != "slow" {
+		t.Errorf("entry %v %q", entry.Level, entry.Message)
+	}
+	if fields["socket_id"] != "s1" || fields["req.n"] != int64(3) || fields["req.q.len"] != int64(7) {
+		t.Errorf("fields %v", fields)
+	}
+	if !strings.HasSuffix(entry.Caller.File, "log_test.go") {
+		t.Errorf("caller %s, want the slog call site", entry.Caller.File)
+	}
+}
@@ -29,6 +29,7 @@ type ProtoMessage struct {
 	Code          int32                  `protobuf:"varint,4,opt,name=code,proto3" json:"code,omitempty"`
 	Message       string                 `protobuf:"bytes,5,opt,name=message,proto3" json:"message,omitempty"`
 	Data          []byte                 `protobuf:"bytes,6,opt,name=data,proto3" json:"data,omitempty"`
+	Metadata      map[string]string      `protobuf:"bytes,7,rep,name=metadata,proto3" json:"metadata,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -105,11 +106,18 @@ func (x *ProtoMessage) GetData() []byte {
 	return nil
 }
 
+func (x *ProtoMessage) GetMetadata() map[string]string {
+	if x != nil {
+		return x.Metadata
+	}
+	return nil
+}
+
 var File_pb_message_proto protoreflect.FileDescriptor
 
 const file_pb_message_proto_rawDesc = "" +
 	"\n" +
-	"\x10pb/message.proto\x12\twebsocket\"\xa6\x01\n" +
+	"\x10pb/message.proto\x12\twebsocket\"\xa6\x02\n" +
 	"\fProtoMessage\x12\x1d\n" +
 	"\n" +
 	"request_id\x18\x01 \x01(\tR\trequestId\x12\x1b\n" +
@@ -117,7 +125,11 @@ const file_pb_message_proto_rawDesc = "" +
 	"\acommand\x18\x03 \x01(\tR\acommand\x12\x12\n" +
 	"\x04code\x18\x04 \x01(\x05R\x04code\x12\x18\n" +
 	"\amessage\x18\x05 \x01(\tR\amessage\x12\x12\n" +
-	"\x04data\x18\x06 \x01(\fR\x04dataB\rZ\v.;websocketb\x06proto3"
+	"\x04data\x18\x06 \x01(\fR\x04data\x12A\n" +
+	"\bmetadata\x18\a \x03(\v2%.websocket.ProtoMessage.MetadataEntryR\bmetadata\x1a;\n" +
+	"\rMetadataEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01B\rZ\v.;websocketb\x06proto3"
 
 var (
 	file_pb_message_proto_rawDescOnce sync.Once
@@ -131,16 +143,18 @@ func file_pb_message_proto_rawDescGZIP() []byte {
 	return file_pb_message_proto_rawDescData
 }
 
-var file_pb_message_proto_msgTypes = make([]protoimpl.MessageInfo, 1)
+var file_pb_message_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
 var file_pb_message_proto_goTypes = []any{
 	(*ProtoMessage)(nil), // 0: websocket.ProtoMessage
+	nil,                  // 1: websocket.ProtoMessage.MetadataEntry
 }
 var file_pb_message_proto_depIdxs = []int32{
-	0, // [0:0] is the sub-list for method output_type
-	0, // [0:0] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	1, // 0: websocket.ProtoMessage.metadata:type_name -> websocket.ProtoMessage.MetadataEntry
+	1, // [1:1] is the sub-list for method output_type
+	1, // [1:1] is the sub-list for method input_type
+	1, // [1:1] is the sub-list for extension type_name
+	1, // [1:1] is the sub-list for extension extendee
+	0, // [0:1] is the sub-list for field type_name
 }
 
 func init() { file_pb_message_proto_init() }
@@ -154,7 +168,7 @@ func file_pb_message_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_pb_message_proto_rawDesc), len(file_pb_message_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   1,
+			NumMessages:   2,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
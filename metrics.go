@@ -0,0 +1,58 @@
+package websocket
+
+import (
+	"github.com/gorilla/websocket"
+	"time"
+)
+
+// Metrics receives the engine instrumentation events. Implementations must be
+// safe for concurrent use and cheap, they are called on the read and write
+// paths. Codecs are "json" for text clients and "proto" for binary clients.
+type Metrics interface {
+	// ConnectionOpened is called once a client is registered.
+	ConnectionOpened()
+	// ConnectionClosed is called once a client is released.
+	ConnectionClosed(reason DisconnectKind)
+	// MessageReceived is called for each message routed to a registered command.
+	MessageReceived(command, codec string)
+	// MessageSent is called for each message written to a client, command is
+	// empty for messages sent without one such as Publish and Client.Send.
+	MessageSent(command, codec string)
+	// BytesReceived is called with the size of each data frame read.
+	BytesReceived(codec string, n int)
+	// BytesSent is called with the size of each message written.
+	BytesSent(codec string, n int)
+	// HandlerDuration is called with the run time of each command handler.
+	HandlerDuration(command, codec string, d time.Duration)
+	// QueueDepth is called with the change of the messages queued across all clients.
+	QueueDepth(delta int)
+	// MessagesDropped is called with the messages dropped by backpressure policies.
+	MessagesDropped(n int)
+	// Published is called once a publish has been queued to its subscribers.
+	Published(fanout int, d time.Duration)
+	// HeartbeatTimeout is called when a client is closed for being idle.
+	HeartbeatTimeout()
+}
+
+// NopMetrics discards all events, it is the engine default.
+type NopMetrics struct{}
+
+func (NopMetrics) ConnectionOpened()                             {}
+func (NopMetrics) ConnectionClosed(DisconnectKind)               {}
+func (NopMetrics) MessageReceived(string, string)                {}
+func (NopMetrics) MessageSent(string, string)                    {}
+func (NopMetrics) BytesReceived(string, int)                     {}
+func (NopMetrics) BytesSent(string, int)                         {}
+func (NopMetrics) HandlerDuration(string, string, time.Duration) {}
+func (NopMetrics) QueueDepth(int)                                {}
+func (NopMetrics) MessagesDropped(int)                           {}
+func (NopMetrics) Published(int, time.Duration)                  {}
+func (NopMetrics) HeartbeatTimeout()                             {}
+
+// codec returns the metrics label of a message type.
+func codec(messageType int) string {
+	if messageType == websocket.BinaryMessage {
+		return "proto"
+	}
+	return "json"
+}
@@ -3,6 +3,13 @@ package websocket
 import (
 	"github.com/gin-generator/logger"
 	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"log/slog"
+	"net/http"
+	"os"
+	"syscall"
+	"time"
 )
 
 type (
@@ -12,9 +19,21 @@ type (
 	EngineOption interface {
 		apply(*Engine)
 	}
+	SendOption interface {
+		apply(*frame)
+	}
+	RouteOption interface {
+		apply(*routeConfig)
+	}
+	EndpointOption interface {
+		apply(*endpoint)
+	}
 
-	optionFunc       func(*Client)
-	engineOptionFunc func(*Engine)
+	optionFunc         func(*Client)
+	engineOptionFunc   func(*Engine)
+	sendOptionFunc     func(*frame)
+	routeOptionFunc    func(*routeConfig)
+	endpointOptionFunc func(*endpoint)
 )
 
 func (f optionFunc) apply(client *Client) {
@@ -25,6 +44,18 @@ func (m engineOptionFunc) apply(manager *Engine) {
 	m(manager)
 }
 
+func (s sendOptionFunc) apply(f *frame) {
+	s(f)
+}
+
+func (r routeOptionFunc) apply(config *routeConfig) {
+	r(config)
+}
+
+func (p endpointOptionFunc) apply(ep *endpoint) {
+	p(ep)
+}
+
 func newClientWithOptions(conn *websocket.Conn, opts ...Option) *Client {
 	client := newDefaultClient(conn)
 
@@ -37,7 +68,35 @@ func newClientWithOptions(conn *websocket.Conn, opts ...Option) *Client {
 
 func WithSendLimit(sendLimit int) Option {
 	return optionFunc(func(c *Client) {
-		c.message = make(chan []byte, sendLimit)
+		c.queue.maxLen = sendLimit
+	})
+}
+
+// WithSendBytesLimit limits the bytes waiting in the outbound queue, 0 means no limit.
+func WithSendBytesLimit(bytes int) Option {
+	return optionFunc(func(c *Client) {
+		c.queue.maxBytes = bytes
+	})
+}
+
+// WithBackpressure sets what happens when the outbound queue is full, timeout only applies to BlockWithTimeout.
+func WithBackpressure(policy BackpressurePolicy, timeout time.Duration) Option {
+	return optionFunc(func(c *Client) {
+		c.queue.policy = policy
+		c.queue.timeout = timeout
+	})
+}
+
+// WithBatching writes queued messages in batches to clients that opt in with
+// the BatchQuery parameter of their upgrade request, such as the client
+// package; other clients get plain messages. Batches are a JSON array of
+// messages for text clients, length-prefixed messages (big-endian uint32) for
+// binary clients. A batch is written once it reaches maxBytes (BatchBytes when
+// 0), or maxDelay after its first message; with no delay it is written as soon
+// as the queue is drained. A batch of one message is written as a plain message.
+func WithBatching(maxBytes int, maxDelay time.Duration) Option {
+	return optionFunc(func(c *Client) {
+		c.batch = newBatcher(maxBytes, maxDelay)
 	})
 }
 
@@ -53,17 +112,98 @@ func WithInterval(interval int64) Option {
 	})
 }
 
+// WithPingInterval sends a ping frame every interval milliseconds, pongs refresh the heartbeat.
+func WithPingInterval(interval int64) Option {
+	return optionFunc(func(c *Client) {
+		c.pingTime = interval
+	})
+}
+
+// WithRateLimit limits the messages a connection may send, Client.SetRateLimit changes it at runtime.
+func WithRateLimit(limit Limit) Option {
+	return optionFunc(func(c *Client) {
+		c.rateLimit.config.set(limit)
+	})
+}
+
 func WithProtocol(protocol int) Option {
 	return optionFunc(func(c *Client) {
 		if protocol == websocket.TextMessage || protocol == websocket.BinaryMessage {
-			c.protocol = protocol
+			c.protocol.Store(int32(protocol))
 		}
 	})
 }
 
+// WithMaxAsync limits the async handlers the client may run at once, MaxAsync
+// by default; requests over it are answered with 429. 0 removes the limit.
+func WithMaxAsync(n int) Option {
+	return optionFunc(func(c *Client) {
+		c.maxAsync = int32(n)
+	})
+}
+
+// WithClientValues sets the initial context values of the client.
 func WithClientValues(values map[any]any) Option {
 	return optionFunc(func(c *Client) {
-		c.values = values
+		for key, value := range values {
+			c.values.Store(key, value)
+		}
+	})
+}
+
+// WithCoalesceKey lets the message replace a queued one with the same key under the Coalesce policy.
+func WithCoalesceKey(key string) SendOption {
+	return sendOptionFunc(func(f *frame) {
+		f.key = key
+	})
+}
+
+// WithPriority queues the message in the given priority lane.
+func WithPriority(priority Priority) SendOption {
+	return sendOptionFunc(func(f *frame) {
+		if priority >= PriorityControl && priority < priorityLanes {
+			f.priority = priority
+		}
+	})
+}
+
+// WithCommandRateLimit limits how often each connection may invoke the command.
+func WithCommandRateLimit(limit Limit) RouteOption {
+	return routeOptionFunc(func(config *routeConfig) {
+		config.setLimit(limit)
+	})
+}
+
+// WithCommandMaxSize limits the size of the command's messages below the engine limit.
+func WithCommandMaxSize(size int) RouteOption {
+	return routeOptionFunc(func(config *routeConfig) {
+		config.maxSize = size
+	})
+}
+
+// WithCommandTimeout bounds the handler of the command, overriding WithHandlerTimeout.
+func WithCommandTimeout(timeout time.Duration) RouteOption {
+	return routeOptionFunc(func(config *routeConfig) {
+		config.timeout = timeout
+	})
+}
+
+// WithAsync runs the handler of the command on its own goroutine, so the
+// client's next messages are read meanwhile; a cancel command can then abort
+// it. Replies may come out of order, clients match them by request id. A
+// client runs up to WithMaxAsync handlers at once. Shutdown waits for them
+// until its context is done, so a handler ignoring its context holds it up to
+// the deadline, or to WithCommandTimeout or WithHandlerTimeout when set.
+func WithAsync() RouteOption {
+	return routeOptionFunc(func(config *routeConfig) {
+		config.async = true
+	})
+}
+
+// WithCommandMaxDataSize limits the size of the command's Data field.
+func WithCommandMaxDataSize(size int) RouteOption {
+	return routeOptionFunc(func(config *routeConfig) {
+		config.maxDataSize = size
 	})
 }
 
@@ -74,16 +214,72 @@ func NewEngineWithOptions(opts ...EngineOption) *Engine {
 		opt.apply(engine)
 	}
 
-	go engine.waitForShutdown()
+	if engine.logLevel != nil {
+		engine.log = slog.New(&levelHandler{level: engine.logLevel, Handler: engine.log.Handler()})
+	}
+	engine.endpoint = engine.newEndpoint()
+	engine.wheel = newTimingWheel(engine.wheelTick, engine.wheelSlots)
+	go engine.wheel.run(engine.clock)
+	if len(engine.signals) > 0 {
+		go engine.waitForShutdown()
+	}
 	return engine
 }
 
+// WithClientOptions sets the client options of every connection, ServeHTTP
+// uses them alone and endpoints apply theirs after them.
+func WithClientOptions(opts ...Option) EngineOption {
+	return engineOptionFunc(func(m *Engine) {
+		m.clientOptions = append(m.clientOptions, opts...)
+	})
+}
+
+// WithMaxConn limits the concurrent connections of the engine, 0 means unlimited.
 func WithMaxConn(maxConn uint32) EngineOption {
 	return engineOptionFunc(func(m *Engine) {
 		m.maxConn = maxConn
 	})
 }
 
+// WithMaxConnPerIP limits the concurrent connections from one client address, 0 means unlimited.
+func WithMaxConnPerIP(maxConn int) EngineOption {
+	return engineOptionFunc(func(m *Engine) {
+		m.maxConnPerIP = maxConn
+	})
+}
+
+// WithConnRateLimit limits how many upgrades per second the engine accepts,
+// smoothing reconnect storms.
+func WithConnRateLimit(rate float64, burst int) EngineOption {
+	return engineOptionFunc(func(m *Engine) {
+		m.admission.rate.config.set(Limit{Rate: rate, Burst: burst})
+	})
+}
+
+// WithUserResolver authenticates upgrade requests before upgrading: an error
+// refuses the request with 401, a user id binds the connection to that user and
+// lets per-user caps refuse the request before upgrading.
+func WithUserResolver(resolver UserResolver) EngineOption {
+	return engineOptionFunc(func(m *Engine) {
+		m.userResolver = resolver
+	})
+}
+
+// WithClientIP sets how the client address used by WithMaxConnPerIP is read
+// from the request, the remote address by default.
+func WithClientIP(clientIP func(r *http.Request) string) EngineOption {
+	return engineOptionFunc(func(m *Engine) {
+		m.clientIP = clientIP
+	})
+}
+
+// WithRetryAfter sets the Retry-After of upgrades refused with 503.
+func WithRetryAfter(retryAfter time.Duration) EngineOption {
+	return engineOptionFunc(func(m *Engine) {
+		m.retryAfter = retryAfter
+	})
+}
+
 func WithReadBufferSize(size int) EngineOption {
 	return engineOptionFunc(func(m *Engine) {
 		m.readBufferSize = size
@@ -96,6 +292,35 @@ func WithWriteBufferSize(size int) EngineOption {
 	})
 }
 
+// WithMaxMessageSize limits the size of inbound frames, larger frames close the
+// client with CloseMessageTooBig before being buffered. 0 means no limit.
+func WithMaxMessageSize(size int64) EngineOption {
+	return engineOptionFunc(func(m *Engine) {
+		m.maxMessageSize = size
+	})
+}
+
+// WithMaxDataSize limits the size of the Data field of inbound messages, 0 means no limit.
+func WithMaxDataSize(size int) EngineOption {
+	return engineOptionFunc(func(m *Engine) {
+		m.maxDataSize = size
+	})
+}
+
+// WithMaxJSONDepth limits the nesting depth of inbound JSON messages, 0 means no limit.
+func WithMaxJSONDepth(depth int) EngineOption {
+	return engineOptionFunc(func(m *Engine) {
+		m.maxJSONDepth = depth
+	})
+}
+
+// WithMaxProtoFields limits the number of fields of inbound proto messages, 0 means no limit.
+func WithMaxProtoFields(fields int) EngineOption {
+	return engineOptionFunc(func(m *Engine) {
+		m.maxProtoFields = fields
+	})
+}
+
 func WithPublishWorkPool(poolSize int) EngineOption {
 	return engineOptionFunc(func(m *Engine) {
 		m.workPool = poolSize
@@ -108,8 +333,227 @@ func WithSubscribeEngine(storage Memory) EngineOption {
 	})
 }
 
+// WithTimingWheel sets the tick and slot count of the timing wheel driving client timers.
+func WithTimingWheel(tick time.Duration, slots int) EngineOption {
+	return engineOptionFunc(func(m *Engine) {
+		m.wheelTick = tick
+		m.wheelSlots = slots
+	})
+}
+
+// WithClock sets the time source of heartbeats, pings, the timing wheel and
+// rate limits, the wall clock by default.
+func WithClock(clock Clock) EngineOption {
+	return engineOptionFunc(func(m *Engine) {
+		m.clock = clock
+	})
+}
+
+// WithHandlerTimeout bounds every handler, 0 means no timeout. Past it the
+// handler context is cancelled and the request is replied 504 at once; the
+// handler should return when its context is done.
+func WithHandlerTimeout(timeout time.Duration) EngineOption {
+	return engineOptionFunc(func(m *Engine) {
+		m.handlerTimeout = timeout
+	})
+}
+
+// WithMaxConnPerUser limits the concurrent connections of one user, 0 means unlimited.
+func WithMaxConnPerUser(limit int, policy UserConnPolicy) EngineOption {
+	return engineOptionFunc(func(m *Engine) {
+		m.users.limit = limit
+		m.users.policy = policy
+	})
+}
+
+// WithUserRateLimit limits the messages a user may send across all of their connections.
+func WithUserRateLimit(limit Limit) EngineOption {
+	return engineOptionFunc(func(m *Engine) {
+		m.userLimit.set(limit)
+	})
+}
+
+// WithReconnectHint sets the close reason sent with the going-away frame on Shutdown, e.g. a fallback address.
+func WithReconnectHint(hint string) EngineOption {
+	return engineOptionFunc(func(m *Engine) {
+		m.reconnectHint = hint
+	})
+}
+
+// WithSignalShutdown calls Shutdown with the given timeout when one of signals
+// (SIGINT and SIGTERM by default) is received. Signals are not handled otherwise.
+func WithSignalShutdown(timeout time.Duration, signals ...os.Signal) EngineOption {
+	return engineOptionFunc(func(m *Engine) {
+		if len(signals) == 0 {
+			signals = []os.Signal{syscall.SIGINT, syscall.SIGTERM}
+		}
+		m.signals = signals
+		m.signalTimeout = timeout
+	})
+}
+
+// WithOnConnect adds a hook run before a client is registered, it may reject the client.
+func WithOnConnect(hook ConnectHook) EngineOption {
+	return engineOptionFunc(func(m *Engine) {
+		m.hooks.onConnect = append(m.hooks.onConnect, hook)
+	})
+}
+
+// WithOnDisconnect adds a hook called with the reason and session duration once a client is released.
+func WithOnDisconnect(hook DisconnectHook) EngineOption {
+	return engineOptionFunc(func(m *Engine) {
+		m.hooks.onDisconnect = append(m.hooks.onDisconnect, hook)
+	})
+}
+
+// WithOnMessage adds a hook observing every raw data frame read from a client.
+func WithOnMessage(hook FrameHook) EngineOption {
+	return engineOptionFunc(func(m *Engine) {
+		m.hooks.onMessage = append(m.hooks.onMessage, hook)
+	})
+}
+
+// WithOnSend adds a hook observing every raw data frame written to a client.
+func WithOnSend(hook FrameHook) EngineOption {
+	return engineOptionFunc(func(m *Engine) {
+		m.hooks.onSend = append(m.hooks.onSend, hook)
+	})
+}
+
+// WithOnError adds a hook observing the errors replied to clients and handler panics.
+func WithOnError(hook ErrorHook) EngineOption {
+	return engineOptionFunc(func(m *Engine) {
+		m.hooks.onError = append(m.hooks.onError, hook)
+	})
+}
+
+// WithMetrics sets the receiver of the engine instrumentation events, e.g. a
+// PrometheusMetrics. Events are discarded by default.
+func WithMetrics(metrics Metrics) EngineOption {
+	return engineOptionFunc(func(m *Engine) {
+		m.metrics = metrics
+	})
+}
+
+// WithTracerProvider enables tracing: each inbound message and publish gets a
+// span from the provider, handlers registered with a context receive it.
+func WithTracerProvider(provider trace.TracerProvider) EngineOption {
+	return engineOptionFunc(func(m *Engine) {
+		m.tracer = provider.Tracer(TracerName)
+	})
+}
+
+// WithPropagator sets how trace context is carried in message metadata, W3C
+// trace context by default.
+func WithPropagator(propagator propagation.TextMapPropagator) EngineOption {
+	return engineOptionFunc(func(m *Engine) {
+		m.propagator = propagator
+	})
+}
+
+// WithLogger writes the engine logs to a gin-generator logger.
 func WithLogger(logger *logger.Logger) EngineOption {
+	return engineOptionFunc(func(m *Engine) {
+		m.log = slog.New(newZapHandler(logger.Log))
+	})
+}
+
+// WithSlog writes the engine logs to a slog logger.
+func WithSlog(logger *slog.Logger) EngineOption {
 	return engineOptionFunc(func(m *Engine) {
 		m.log = logger
 	})
 }
+
+// WithLogLevel drops engine logs below level, whatever the logger level.
+func WithLogLevel(level slog.Leveler) EngineOption {
+	return engineOptionFunc(func(m *Engine) {
+		m.logLevel = level
+	})
+}
+
+// WithLogSampling samples the per-message logs: in every tick each kind of
+// record is logged the first times, then once every thereafter times, none
+// when thereafter is 0.
+func WithLogSampling(tick time.Duration, first, thereafter int) EngineOption {
+	return engineOptionFunc(func(m *Engine) {
+		m.logSampler = &logSampler{tick: tick, first: uint64(first), thereafter: uint64(thereafter)}
+	})
+}
+
+// WithEndpointName names the endpoint, its clients log it in the endpoint field.
+func WithEndpointName(name string) EndpointOption {
+	return endpointOptionFunc(func(ep *endpoint) {
+		ep.name = name
+	})
+}
+
+// WithEndpointClientOptions sets the client options of the endpoint, applied after those of WithClientOptions.
+func WithEndpointClientOptions(opts ...Option) EndpointOption {
+	return endpointOptionFunc(func(ep *endpoint) {
+		ep.clientOptions = append(ep.clientOptions, opts...)
+	})
+}
+
+// WithEndpointBufferSize sets the read and write buffer sizes of the endpoint connections.
+func WithEndpointBufferSize(read, write int) EndpointOption {
+	return endpointOptionFunc(func(ep *endpoint) {
+		ep.readBufferSize, ep.writeBufferSize = read, write
+	})
+}
+
+// WithEndpointMaxMessageSize limits the size of a message read from the endpoint connections.
+func WithEndpointMaxMessageSize(size int64) EndpointOption {
+	return endpointOptionFunc(func(ep *endpoint) {
+		ep.maxMessageSize = size
+	})
+}
+
+// WithOriginCheck sets the check of the Origin header of upgrade requests,
+// every origin is accepted by default.
+func WithOriginCheck(check func(r *http.Request) bool) EndpointOption {
+	return endpointOptionFunc(func(ep *endpoint) {
+		ep.checkOrigin = check
+	})
+}
+
+// WithEndpointUserResolver authenticates the upgrade requests of the endpoint
+// instead of the engine resolver.
+func WithEndpointUserResolver(resolver UserResolver) EndpointOption {
+	return endpointOptionFunc(func(ep *endpoint) {
+		ep.userResolver = resolver
+	})
+}
+
+// WithEndpointOnConnect adds a connect hook run after the engine ones for the
+// endpoint clients, in the order they were added.
+func WithEndpointOnConnect(hook ConnectHook) EndpointOption {
+	return endpointOptionFunc(func(ep *endpoint) {
+		ep.onConnect = append(ep.onConnect, hook)
+	})
+}
+
+// WithCodecs restricts the message types the endpoint clients may use,
+// websocket.TextMessage for JSON and websocket.BinaryMessage for proto.
+// Clients sending another type are disconnected.
+func WithCodecs(protocols ...int) EndpointOption {
+	return endpointOptionFunc(func(ep *endpoint) {
+		ep.protocols = protocols
+	})
+}
+
+// WithAllowedCommands restricts the endpoint clients to the commands matching
+// patterns; a pattern ending with * allows a namespace, e.g. "chat.*".
+func WithAllowedCommands(patterns ...string) EndpointOption {
+	return endpointOptionFunc(func(ep *endpoint) {
+		ep.allow = append(ep.allow, patterns...)
+	})
+}
+
+// WithDeniedCommands forbids the endpoint clients the commands matching
+// patterns, even allowed ones; a pattern ending with * denies a namespace.
+func WithDeniedCommands(patterns ...string) EndpointOption {
+	return endpointOptionFunc(func(ep *endpoint) {
+		ep.deny = append(ep.deny, patterns...)
+	})
+}
@@ -0,0 +1,327 @@
+package websocket
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// DurationBuckets are the histogram buckets of handler and publish durations, in seconds.
+	DurationBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5}
+	// FanoutBuckets are the histogram buckets of publish fanout sizes.
+	FanoutBuckets = []float64{1, 10, 100, 1000, 10000, 100000}
+)
+
+// PrometheusMetrics implements Metrics and serves them in the Prometheus text
+// exposition format, e.g. mux.Handle("/metrics", metrics).
+type PrometheusMetrics struct {
+	namespace         string
+	connections       atomic.Int64
+	opened            atomic.Uint64
+	closed            *counterVec // reason
+	received          *counterVec // command, codec
+	sent              *counterVec // command, codec
+	receivedBytes     *counterVec // codec
+	sentBytes         *counterVec // codec
+	handlerDuration   *histogramVec
+	queueDepth        atomic.Int64
+	dropped           atomic.Uint64
+	fanout            *histogram
+	publishDuration   *histogram
+	heartbeatTimeouts atomic.Uint64
+}
+
+// NewPrometheusMetrics creates the metrics with names prefixed by namespace, "websocket" when empty.
+func NewPrometheusMetrics(namespace string) *PrometheusMetrics {
+	if namespace == "" {
+		namespace = "websocket"
+	}
+	return &PrometheusMetrics{
+		namespace:       namespace,
+		closed:          newCounterVec("reason"),
+		received:        newCounterVec("command", "codec"),
+		sent:            newCounterVec("command", "codec"),
+		receivedBytes:   newCounterVec("codec"),
+		sentBytes:       newCounterVec("codec"),
+		handlerDuration: newHistogramVec(DurationBuckets, "command", "codec"),
+		fanout:          newHistogram(FanoutBuckets),
+		publishDuration: newHistogram(DurationBuckets),
+	}
+}
+
+func (p *PrometheusMetrics) ConnectionOpened() {
+	p.connections.Add(1)
+	p.opened.Add(1)
+}
+
+func (p *PrometheusMetrics) ConnectionClosed(reason DisconnectKind) {
+	p.connections.Add(-1)
+	p.closed.add(1, reason.String())
+}
+
+func (p *PrometheusMetrics) MessageReceived(command, codec string) {
+	p.received.add(1, command, codec)
+}
+
+func (p *PrometheusMetrics) MessageSent(command, codec string) {
+	p.sent.add(1, command, codec)
+}
+
+func (p *PrometheusMetrics) BytesReceived(codec string, n int) {
+	p.receivedBytes.add(uint64(n), codec)
+}
+
+func (p *PrometheusMetrics) BytesSent(codec string, n int) {
+	p.sentBytes.add(uint64(n), codec)
+}
+
+func (p *PrometheusMetrics) HandlerDuration(command, codec string, d time.Duration) {
+	p.handlerDuration.observe(d.Seconds(), command, codec)
+}
+
+func (p *PrometheusMetrics) QueueDepth(delta int) {
+	p.queueDepth.Add(int64(delta))
+}
+
+func (p *PrometheusMetrics) MessagesDropped(n int) {
+	p.dropped.Add(uint64(n))
+}
+
+func (p *PrometheusMetrics) Published(fanout int, d time.Duration) {
+	p.fanout.observe(float64(fanout))
+	p.publishDuration.observe(d.Seconds())
+}
+
+func (p *PrometheusMetrics) HeartbeatTimeout() {
+	p.heartbeatTimeouts.Add(1)
+}
+
+// ServeHTTP serves the metrics in the Prometheus text exposition format.
+func (p *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_ = p.Write(w)
+}
+
+// Write writes the metrics in the Prometheus text exposition format.
+func (p *PrometheusMetrics) Write(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	n := func(name string) string {
+		return p.namespace + "_" + name
+	}
+
+	writeHeader(b, n("connections"), "Currently registered connections.", "gauge")
+	writeSample(b, n("connections"), "", float64(p.connections.Load()))
+	writeHeader(b, n("connections_opened_total"), "Connections registered.", "counter")
+	writeSample(b, n("connections_opened_total"), "", float64(p.opened.Load()))
+	p.closed.write(b, n("connections_closed_total"), "Connections released, by disconnect reason.")
+	p.received.write(b, n("messages_received_total"), "Messages routed to a command, by command and codec.")
+	p.sent.write(b, n("messages_sent_total"), "Messages written, by command and codec.")
+	p.receivedBytes.write(b, n("received_bytes_total"), "Bytes of data frames read, by codec.")
+	p.sentBytes.write(b, n("sent_bytes_total"), "Bytes of messages written, by codec.")
+	p.handlerDuration.write(b, n("handler_duration_seconds"), "Command handler latency, by command and codec.")
+	writeHeader(b, n("queue_depth"), "Messages queued across all connections.", "gauge")
+	writeSample(b, n("queue_depth"), "", float64(p.queueDepth.Load()))
+	writeHeader(b, n("messages_dropped_total"), "Outbound messages dropped by backpressure policies.", "counter")
+	writeSample(b, n("messages_dropped_total"), "", float64(p.dropped.Load()))
+	writeHeader(b, n("publish_fanout"), "Subscribers reached by a publish.", "histogram")
+	p.fanout.write(b, n("publish_fanout"), "")
+	writeHeader(b, n("publish_duration_seconds"), "Time to queue a publish to its subscribers.", "histogram")
+	p.publishDuration.write(b, n("publish_duration_seconds"), "")
+	writeHeader(b, n("heartbeat_timeouts_total"), "Connections closed for being idle.", "counter")
+	writeSample(b, n("heartbeat_timeouts_total"), "", float64(p.heartbeatTimeouts.Load()))
+	return b.Flush()
+}
+
+// counterVec is a counter partitioned by label values.
+type counterVec struct {
+	mux    *sync.RWMutex
+	labels []string
+	series map[string]*counterSeries
+}
+
+type counterSeries struct {
+	labels string // formatted label pairs
+	value  atomic.Uint64
+}
+
+func newCounterVec(labels ...string) *counterVec {
+	return &counterVec{
+		mux:    new(sync.RWMutex),
+		labels: labels,
+		series: make(map[string]*counterSeries),
+	}
+}
+
+func (v *counterVec) add(n uint64, values ...string) {
+	key := strings.Join(values, "\xff")
+	v.mux.RLock()
+	s, ok := v.series[key]
+	v.mux.RUnlock()
+	if !ok {
+		v.mux.Lock()
+		if s, ok = v.series[key]; !ok {
+			s = &counterSeries{labels: formatLabels(v.labels, values)}
+			v.series[key] = s
+		}
+		v.mux.Unlock()
+	}
+	s.value.Add(n)
+}
+
+func (v *counterVec) write(w *bufio.Writer, name, help string) {
+	writeHeader(w, name, help, "counter")
+	v.mux.RLock()
+	defer v.mux.RUnlock()
+	for _, key := range sortedKeys(v.series) {
+		s := v.series[key]
+		writeSample(w, name, s.labels, float64(s.value.Load()))
+	}
+}
+
+// histogram counts observations in cumulative buckets.
+type histogram struct {
+	mux    *sync.Mutex
+	bounds []float64
+	counts []uint64 // per bucket, the last one is +Inf
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{
+		mux:    new(sync.Mutex),
+		bounds: bounds,
+		counts: make([]uint64, len(bounds)+1),
+	}
+}
+
+func (h *histogram) observe(v float64) {
+	i, _ := slices.BinarySearch(h.bounds, v)
+	h.mux.Lock()
+	h.counts[i]++
+	h.sum += v
+	h.count++
+	h.mux.Unlock()
+}
+
+// write writes the bucket, sum and count samples, labels are formatted label pairs.
+func (h *histogram) write(w *bufio.Writer, name, labels string) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	var cumulative uint64
+	for i, count := range h.counts {
+		cumulative += count
+		le := math.Inf(1)
+		if i < len(h.bounds) {
+			le = h.bounds[i]
+		}
+		bucket := `le="` + formatFloat(le) + `"`
+		if labels != "" {
+			bucket = labels + "," + bucket
+		}
+		writeSample(w, name+"_bucket", bucket, float64(cumulative))
+	}
+	writeSample(w, name+"_sum", labels, h.sum)
+	writeSample(w, name+"_count", labels, float64(h.count))
+}
+
+// histogramVec is a histogram partitioned by label values.
+type histogramVec struct {
+	mux    *sync.RWMutex
+	bounds []float64
+	labels []string
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	labels string
+	*histogram
+}
+
+func newHistogramVec(bounds []float64, labels ...string) *histogramVec {
+	return &histogramVec{
+		mux:    new(sync.RWMutex),
+		bounds: bounds,
+		labels: labels,
+		series: make(map[string]*histogramSeries),
+	}
+}
+
+func (v *histogramVec) observe(value float64, values ...string) {
+	key := strings.Join(values, "\xff")
+	v.mux.RLock()
+	s, ok := v.series[key]
+	v.mux.RUnlock()
+	if !ok {
+		v.mux.Lock()
+		if s, ok = v.series[key]; !ok {
+			s = &histogramSeries{labels: formatLabels(v.labels, values), histogram: newHistogram(v.bounds)}
+			v.series[key] = s
+		}
+		v.mux.Unlock()
+	}
+	s.observe(value)
+}
+
+func (v *histogramVec) write(w *bufio.Writer, name, help string) {
+	writeHeader(w, name, help, "histogram")
+	v.mux.RLock()
+	defer v.mux.RUnlock()
+	for _, key := range sortedKeys(v.series) {
+		s := v.series[key]
+		s.write(w, name, s.labels)
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatLabels formats label pairs as name="value",...
+func formatLabels(names, values []string) string {
+	var b strings.Builder
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name)
+		b.WriteString(`="`)
+		b.WriteString(labelEscaper.Replace(values[i]))
+		b.WriteByte('"')
+	}
+	return b.String()
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func writeHeader(w *bufio.Writer, name, help, typ string) {
+	_, _ = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func writeSample(w *bufio.Writer, name, labels string, value float64) {
+	if labels != "" {
+		name += "{" + labels + "}"
+	}
+	_, _ = fmt.Fprintf(w, "%s %s\n", name, formatFloat(value))
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
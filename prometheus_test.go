@@ -0,0 +1,80 @@
+package websocket
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	ws "github.com/gorilla/websocket"
+)
+
+func TestPrometheusMetricsWrite(t *testing.T) {
+	m := NewPrometheusMetrics("")
+	m.ConnectionOpened()
+	m.ConnectionOpened()
+	m.ConnectionClosed(DisconnectIdleTimeout)
+	m.MessageReceived(`say"hi`, "json")
+	m.HandlerDuration("ping", "proto", 3*time.Millisecond)
+	m.Published(42, time.Millisecond)
+
+	var b strings.Builder
+	if err := m.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+	for _, want := range []string{
+		"# TYPE websocket_connections gauge\nwebsocket_connections 1\n",
+		"websocket_connections_opened_total 2\n",
+		`websocket_connections_closed_total{reason="idle_timeout"} 1`,
+		`websocket_messages_received_total{command="say\"hi",codec="json"} 1`,
+		`websocket_handler_duration_seconds_bucket{command="ping",codec="proto",le="0.0025"} 0`,
+		`websocket_handler_duration_seconds_bucket{command="ping",codec="proto",le="0.005"} 1`,
+		`websocket_handler_duration_seconds_count{command="ping",codec="proto"} 1`,
+		`websocket_publish_fanout_bucket{le="100"} 1`,
+		`websocket_publish_fanout_sum 42`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in:\n%s", want, out)
+		}
+	}
+}
+
+func TestPrometheusMetricsEngine(t *testing.T) {
+	m := NewPrometheusMetrics("ws")
+	e := newTestEngine(WithMetrics(m))
+	e.RegisterJsonRouter("echo", func(msg *JsonMessage) {})
+	url, stop := dialAdmission(t, e)
+	defer stop()
+
+	conn, _, err := ws.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = conn.ReadMessage(); err != nil {
+		t.Fatal(err)
+	}
+	if err = conn.WriteMessage(ws.TextMessage, []byte(`{"request_id":"1","socket_id":"s","command":"echo"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = conn.ReadMessage(); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	var b strings.Builder
+	_ = m.Write(&b)
+	out := b.String()
+	for _, want := range []string{
+		"ws_connections 0\n",
+		"ws_connections_opened_total 1\n",
+		`ws_messages_received_total{command="echo",codec="json"} 1`,
+		`ws_messages_sent_total{command="echo",codec="json"} 1`,
+		`ws_messages_sent_total{command="",codec="json"} 1`,
+		"ws_queue_depth 0\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in:\n%s", want, out)
+		}
+	}
+}
@@ -0,0 +1,332 @@
+package websocket
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"time"
+)
+
+// BackpressurePolicy decides what happens when a client's outbound queue is
+// full. Stream chunks are never dropped, the stream window bounds them instead.
+type BackpressurePolicy int
+
+const (
+	BlockWithTimeout BackpressurePolicy = iota // wait for room up to the timeout (forever when 0), then drop the message
+	DropNewest                                 // drop the message being sent
+	DropOldest                                 // drop queued messages from the front until the new one fits
+	Coalesce                                   // replace the queued message of the same lane with the same coalesce key, else drop the new one
+	DisconnectSlow                             // close the client as a slow consumer
+)
+
+// Priority is the outbound lane of a message, lanes are written in priority order.
+type Priority int
+
+const (
+	PriorityControl   Priority = iota // control messages such as the connected greeting
+	PriorityReply                     // replies to the client's own requests, error replies included
+	PriorityBroadcast                 // published and other server pushed messages
+	priorityLanes
+)
+
+// StarvationLimit is how many times a waiting lane may be passed over by higher
+// priority lanes before it is served anyway.
+const StarvationLimit = 16
+
+var (
+	ErrQueueFull    = errors.New("client send queue is full")
+	ErrSlowConsumer = errors.New("client disconnected as slow consumer")
+	ErrClientClosed = errors.New("client is closed")
+)
+
+// frame is an outbound message waiting in a client queue.
+type frame struct {
+	data     []byte
+	payload  *payload // shared broadcast payload data was encoded from, if any
+	command  string   // command the message answers or carries, for metrics
+	key      string   // coalesce key
+	priority Priority
+	keep     bool   // never dropped by DropNewest, DropOldest or Coalesce, its sender bounds how many are queued
+	done     func() // called once the frame leaves the queue, written or not
+}
+
+// outQueue is the bounded outbound queue of a client, limited both by message
+// count and by bytes across its priority lanes. The write loop is woken through notify.
+type outQueue struct {
+	mux      *sync.Mutex
+	lanes    [priorityLanes][]frame
+	skipped  [priorityLanes]int // times each waiting lane was passed over
+	length   int
+	bytes    int
+	maxLen   int // 0 means no message count limit
+	maxBytes int // 0 means no byte limit
+	policy   BackpressurePolicy
+	timeout  time.Duration
+	dropped  uint64
+	notify   chan struct{}
+	space    chan struct{} // closed when room is made, nil without waiters
+	closed   bool
+	depth    func(delta int) // reports queue length changes, may be nil
+}
+
+func newOutQueue(maxLen int) *outQueue {
+	return &outQueue{
+		mux:    new(sync.Mutex),
+		maxLen: maxLen,
+		notify: make(chan struct{}, 1),
+	}
+}
+
+// push enqueues f according to the policy. It returns how many messages were dropped.
+func (q *outQueue) push(f frame, closed <-chan struct{}) (dropped int, err error) {
+	var timeout <-chan time.Time
+	if q.policy == BlockWithTimeout && q.timeout > 0 {
+		timer := time.NewTimer(q.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	for {
+		q.mux.Lock()
+		if q.closed {
+			q.mux.Unlock()
+			return 0, ErrClientClosed
+		}
+		if !q.full(len(f.data)) {
+			q.append(f)
+			q.mux.Unlock()
+			q.signal()
+			return
+		}
+
+		switch q.policy {
+		case BlockWithTimeout:
+			if q.space == nil {
+				q.space = make(chan struct{})
+			}
+			space := q.space
+			q.mux.Unlock()
+
+			select {
+			case <-space:
+				continue
+			case <-timeout:
+				return q.drop(1), ErrQueueFull
+			case <-closed:
+				return 0, ErrClientClosed
+			}
+		case DropOldest:
+			for q.full(len(f.data)) {
+				lane, i := q.victim(f.priority)
+				if lane < 0 {
+					break
+				}
+				q.remove(lane, i)
+				dropped++
+			}
+			if q.full(len(f.data)) && !f.keep {
+				q.dropped += uint64(dropped + 1)
+				q.mux.Unlock()
+				return dropped + 1, ErrQueueFull
+			}
+			q.append(f)
+			q.dropped += uint64(dropped)
+			q.mux.Unlock()
+			q.signal()
+			return
+		case Coalesce:
+			if queued := q.find(f.key, f.priority); queued != nil && q.fits(len(f.data)-len(queued.data)) {
+				q.bytes += len(f.data) - len(queued.data)
+				if queued.done != nil {
+					queued.done()
+				}
+				*queued = f
+				q.dropped++
+				q.mux.Unlock()
+				return 1, nil
+			}
+			if f.keep {
+				q.append(f)
+				q.mux.Unlock()
+				q.signal()
+				return
+			}
+			q.mux.Unlock()
+			return q.drop(1), ErrQueueFull
+		case DisconnectSlow:
+			q.mux.Unlock()
+			return q.drop(1), ErrSlowConsumer
+		default:
+			if f.keep {
+				q.append(f)
+				q.mux.Unlock()
+				q.signal()
+				return
+			}
+			q.mux.Unlock()
+			return q.drop(1), ErrQueueFull
+		}
+	}
+}
+
+// pop removes the oldest message of the highest priority lane, unless a lower
+// lane has been passed over StarvationLimit times.
+func (q *outQueue) pop() (f frame, ok bool) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	if q.length == 0 {
+		return
+	}
+
+	lane := -1
+	for i := range q.lanes {
+		if len(q.lanes[i]) == 0 {
+			continue
+		}
+		if lane < 0 {
+			lane = i
+		} else if q.skipped[i] >= StarvationLimit {
+			lane = i
+			break
+		}
+	}
+	for i := lane + 1; i < len(q.lanes); i++ {
+		if len(q.lanes[i]) > 0 {
+			q.skipped[i]++
+		}
+	}
+	q.skipped[lane] = 0
+	return q.take(lane), true
+}
+
+// stats returns the queue depth in messages and bytes and the dropped count.
+func (q *outQueue) stats() (length, bytes int, dropped uint64) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	return q.length, q.bytes, q.dropped
+}
+
+// full reports whether a message of size bytes does not fit, the caller must hold q.mux.
+// A single message larger than maxBytes is still accepted by an empty queue.
+func (q *outQueue) full(size int) bool {
+	if q.maxLen > 0 && q.length >= q.maxLen {
+		return true
+	}
+	return q.maxBytes > 0 && q.length > 0 && q.bytes+size > q.maxBytes
+}
+
+// fits reports whether the queued messages still fit maxBytes once their size
+// changes by delta, as when one is replaced. The caller must hold q.mux.
+func (q *outQueue) fits(delta int) bool {
+	return q.maxBytes == 0 || q.length == 1 || q.bytes+delta <= q.maxBytes
+}
+
+// append adds f at the back of its lane, the caller must hold q.mux.
+func (q *outQueue) append(f frame) {
+	q.lanes[f.priority] = append(q.lanes[f.priority], f)
+	q.length++
+	q.bytes += len(f.data)
+	if q.depth != nil {
+		q.depth(1)
+	}
+}
+
+// victim returns the lane and index of the oldest message of the lowest
+// priority that may be dropped for a message of priority, -1 if none. Kept
+// messages are passed over. The caller must hold q.mux.
+func (q *outQueue) victim(priority Priority) (int, int) {
+	for lane := len(q.lanes) - 1; lane >= int(priority); lane-- {
+		for i := range q.lanes[lane] {
+			if !q.lanes[lane][i].keep {
+				return lane, i
+			}
+		}
+	}
+	return -1, -1
+}
+
+// find returns the message of the priority lane with the coalesce key, the
+// caller must hold q.mux. Other lanes are left alone, so that a replacement
+// never takes the place of a message of another priority.
+func (q *outQueue) find(key string, priority Priority) *frame {
+	if key == "" {
+		return nil
+	}
+	lane := q.lanes[priority]
+	for i := range lane {
+		if lane[i].key == key {
+			return &lane[i]
+		}
+	}
+	return nil
+}
+
+// take removes the front message of lane and wakes blocked senders, the caller must hold q.mux.
+func (q *outQueue) take(lane int) frame {
+	return q.remove(lane, 0)
+}
+
+// remove removes the message i of lane and wakes blocked senders, the caller must hold q.mux.
+func (q *outQueue) remove(lane, i int) frame {
+	f := q.lanes[lane][i]
+	if i == 0 {
+		q.lanes[lane][0] = frame{}
+		q.lanes[lane] = q.lanes[lane][1:]
+	} else {
+		q.lanes[lane] = slices.Delete(q.lanes[lane], i, i+1)
+	}
+	q.length--
+	q.bytes -= len(f.data)
+	if q.depth != nil {
+		q.depth(-1)
+	}
+	if f.done != nil {
+		f.done()
+	}
+	q.wake()
+	return f
+}
+
+// clear discards the queued messages and refuses new ones once the client is released.
+func (q *outQueue) clear() {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	if q.depth != nil && q.length > 0 {
+		q.depth(-q.length)
+	}
+	q.closed = true
+	for _, lane := range q.lanes {
+		for _, f := range lane {
+			if f.done != nil {
+				f.done()
+			}
+		}
+	}
+	q.lanes = [priorityLanes][]frame{}
+	q.length, q.bytes = 0, 0
+	q.wake()
+}
+
+// wake releases blocked senders, the caller must hold q.mux.
+func (q *outQueue) wake() {
+	if q.space != nil {
+		close(q.space)
+		q.space = nil
+	}
+}
+
+// drop counts n dropped messages.
+func (q *outQueue) drop(n int) int {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	q.dropped += uint64(n)
+	return n
+}
+
+// signal wakes the write loop.
+func (q *outQueue) signal() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,196 @@
+package websocket
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func queueData(q *outQueue) (data []string) {
+	for _, lane := range q.lanes {
+		for _, f := range lane {
+			data = append(data, string(f.data))
+		}
+	}
+	return
+}
+
+func TestOutQueuePolicies(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  BackpressurePolicy
+		key     string
+		err     error
+		dropped int
+		want    []string
+	}{
+		{name: "drop newest", policy: DropNewest, err: ErrQueueFull, dropped: 1, want: []string{"a", "b"}},
+		{name: "drop oldest", policy: DropOldest, dropped: 1, want: []string{"b", "c"}},
+		{name: "coalesce hit", policy: Coalesce, key: "a", dropped: 1, want: []string{"c", "b"}},
+		{name: "coalesce miss", policy: Coalesce, key: "x", err: ErrQueueFull, dropped: 1, want: []string{"a", "b"}},
+		{name: "disconnect", policy: DisconnectSlow, err: ErrSlowConsumer, dropped: 1, want: []string{"a", "b"}},
+		{name: "block timeout", policy: BlockWithTimeout, err: ErrQueueFull, dropped: 1, want: []string{"a", "b"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newOutQueue(2)
+			q.policy = tt.policy
+			q.timeout = 10 * time.Millisecond
+			_, _ = q.push(frame{data: []byte("a"), key: "a"}, nil)
+			_, _ = q.push(frame{data: []byte("b"), key: "b"}, nil)
+
+			dropped, err := q.push(frame{data: []byte("c"), key: tt.key}, nil)
+			if !errors.Is(err, tt.err) || dropped != tt.dropped {
+				t.Fatalf("push = (%d, %v), want (%d, %v)", dropped, err, tt.dropped, tt.err)
+			}
+			if got := queueData(q); len(got) != len(tt.want) || got[0] != tt.want[0] || got[1] != tt.want[1] {
+				t.Fatalf("queue = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOutQueueBytesLimit(t *testing.T) {
+	q := newOutQueue(100)
+	q.maxBytes = 4
+	q.policy = DropOldest
+
+	_, _ = q.push(frame{data: []byte("ab")}, nil)
+	_, _ = q.push(frame{data: []byte("cd")}, nil)
+	if dropped, _ := q.push(frame{data: []byte("efg")}, nil); dropped != 2 {
+		t.Fatalf("dropped %d messages, want 2", dropped)
+	}
+	if length, bytes, dropped := q.stats(); length != 1 || bytes != 3 || dropped != 2 {
+		t.Fatalf("stats = (%d, %d, %d), want (1, 3, 2)", length, bytes, dropped)
+	}
+}
+
+func TestOutQueueBlockUntilPop(t *testing.T) {
+	q := newOutQueue(1)
+	_, _ = q.push(frame{data: []byte("a")}, nil)
+
+	done := make(chan error)
+	go func() {
+		_, err := q.push(frame{data: []byte("b")}, nil)
+		done <- err
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	if _, ok := q.pop(); !ok {
+		t.Fatal("expected a queued message")
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("blocked sender was not woken")
+	}
+}
+
+func TestOutQueuePriorityLanes(t *testing.T) {
+	q := newOutQueue(0)
+	for i := 0; i < StarvationLimit+1; i++ {
+		_, _ = q.push(frame{data: []byte("reply"), priority: PriorityReply}, nil)
+	}
+	_, _ = q.push(frame{data: []byte("broadcast"), priority: PriorityBroadcast}, nil)
+	_, _ = q.push(frame{data: []byte("control"), priority: PriorityControl}, nil)
+
+	var order []string
+	for f, ok := q.pop(); ok; f, ok = q.pop() {
+		order = append(order, string(f.data))
+	}
+	if order[0] != "control" {
+		t.Fatalf("first popped %q, want control", order[0])
+	}
+	if order[StarvationLimit] != "broadcast" {
+		t.Fatalf("broadcast popped at %d, want %d: %v", indexOf(order, "broadcast"), StarvationLimit, order)
+	}
+}
+
+func TestOutQueueDropOldestKeepsHigherLanes(t *testing.T) {
+	q := newOutQueue(2)
+	q.policy = DropOldest
+	_, _ = q.push(frame{data: []byte("a"), priority: PriorityReply}, nil)
+	_, _ = q.push(frame{data: []byte("b"), priority: PriorityBroadcast}, nil)
+
+	if _, err := q.push(frame{data: []byte("c"), priority: PriorityReply}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.push(frame{data: []byte("d"), priority: PriorityBroadcast}, nil); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("err = %v, want ErrQueueFull", err)
+	}
+	if got := queueData(q); len(got) != 2 || got[0] != "a" || got[1] != "c" {
+		t.Fatalf("queue = %v, want [a c]", got)
+	}
+}
+
+func indexOf(values []string, value string) int {
+	for i, v := range values {
+		if v == value {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestOutQueueCoalesceKeepsLanesAndBytes(t *testing.T) {
+	q := newOutQueue(2)
+	q.policy = Coalesce
+	q.maxBytes = 6
+	_, _ = q.push(frame{data: []byte("ab"), key: "k", priority: PriorityReply}, nil)
+	_, _ = q.push(frame{data: []byte("cd"), key: "b", priority: PriorityBroadcast}, nil)
+
+	// the key of another lane is not replaced
+	if _, err := q.push(frame{data: []byte("e"), key: "k", priority: PriorityBroadcast}, nil); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("cross-lane coalesce err = %v, want ErrQueueFull", err)
+	}
+	// a replacement over maxBytes is dropped
+	if _, err := q.push(frame{data: []byte("fghij"), key: "k", priority: PriorityReply}, nil); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("oversized coalesce err = %v, want ErrQueueFull", err)
+	}
+	if _, err := q.push(frame{data: []byte("fgh"), key: "k", priority: PriorityReply}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := queueData(q); len(got) != 2 || got[0] != "fgh" || got[1] != "cd" {
+		t.Fatalf("queue = %v, want [fgh cd]", got)
+	}
+	if length, bytes, dropped := q.stats(); length != 2 || bytes != 5 || dropped != 3 {
+		t.Fatalf("stats = (%d, %d, %d), want (2, 5, 3)", length, bytes, dropped)
+	}
+	if len(q.lanes[PriorityReply]) != 1 || len(q.lanes[PriorityBroadcast]) != 1 {
+		t.Fatalf("lanes %v", q.lanes)
+	}
+}
+
+func TestOutQueueKeptFrames(t *testing.T) {
+	for _, policy := range []BackpressurePolicy{DropNewest, DropOldest, Coalesce} {
+		q := newOutQueue(2)
+		q.policy = policy
+		_, _ = q.push(frame{data: []byte("chunk1"), keep: true}, nil)
+		_, _ = q.push(frame{data: []byte("a")}, nil)
+
+		_, err := q.push(frame{data: []byte("chunk2"), keep: true}, nil)
+		if err != nil {
+			t.Fatalf("policy %d: kept frame refused with %v", policy, err)
+		}
+		got := queueData(q)
+		if indexOf(got, "chunk1") < 0 || indexOf(got, "chunk2") < 0 {
+			t.Fatalf("policy %d: queue %v lost a kept frame", policy, got)
+		}
+		if policy == DropOldest && indexOf(got, "a") >= 0 {
+			t.Fatalf("drop oldest kept %v instead of dropping a", got)
+		}
+
+		// only unkept frames make room for others
+		_, err = q.push(frame{data: []byte("b")}, nil)
+		if got = queueData(q); indexOf(got, "chunk1") < 0 || indexOf(got, "chunk2") < 0 {
+			t.Fatalf("policy %d: queue %v lost a kept frame to b", policy, got)
+		}
+		if !errors.Is(err, ErrQueueFull) {
+			t.Fatalf("policy %d: b over kept frames pushed with %v", policy, err)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package websocket
+
+import (
+	"errors"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RateLimitAction decides what happens to a message over its rate limit.
+type RateLimitAction int
+
+const (
+	RateLimitReply      RateLimitAction = iota // reply 429 through handleError
+	RateLimitDrop                              // drop the message silently
+	RateLimitDisconnect                        // disconnect the client
+)
+
+// ErrRateLimited is replied to messages over their rate limit.
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// Limit is a token bucket: Rate messages per second with bursts of up to Burst
+// messages. A zero Rate means unlimited.
+type Limit struct {
+	Rate   float64
+	Burst  int
+	Action RateLimitAction
+}
+
+// limitConfig holds a Limit that can be swapped at runtime, buckets pick up
+// changes on their next message. Without a Limit it is unlimited.
+type limitConfig struct {
+	current atomic.Pointer[Limit]
+}
+
+func (l *limitConfig) set(limit Limit) {
+	l.current.Store(&limit)
+}
+
+// tokenBucket rate limits messages against a limitConfig.
+type tokenBucket struct {
+	mux    *sync.Mutex
+	config *limitConfig
+	limit  *Limit // limit the tokens were computed with
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(config *limitConfig) *tokenBucket {
+	return &tokenBucket{
+		mux:    new(sync.Mutex),
+		config: config,
+	}
+}
+
+// allow takes a token, it reports the action of the limit when none is left.
+func (b *tokenBucket) allow(now time.Time) (RateLimitAction, bool) {
+	if b == nil || b.config == nil {
+		return RateLimitReply, true
+	}
+	limit := b.config.current.Load()
+	if limit == nil || limit.Rate <= 0 {
+		return RateLimitReply, true
+	}
+
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	burst := float64(max(limit.Burst, 1))
+	if b.limit != limit { // new or updated limit, start from a full bucket
+		b.limit, b.tokens, b.last = limit, burst, now
+	}
+	b.tokens = min(burst, b.tokens+now.Sub(b.last).Seconds()*limit.Rate)
+	b.last = now
+	if b.tokens < 1 {
+		return limit.Action, false
+	}
+	b.tokens--
+	return limit.Action, true
+}
+
+// untilFull returns how long the bucket takes to refill, 0 once it is full.
+func (b *tokenBucket) untilFull(now time.Time) time.Duration {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	if b.limit == nil || b.limit.Rate <= 0 || b.limit != b.config.current.Load() {
+		return 0
+	}
+	burst := float64(max(b.limit.Burst, 1))
+	tokens := min(burst, b.tokens+now.Sub(b.last).Seconds()*b.limit.Rate)
+	return time.Duration((burst - tokens) / b.limit.Rate * float64(time.Second))
+}
+
+// expireUserBucket forgets the bucket of a user once it has refilled and the
+// user has no connection left, so that reconnecting does not reset the limit.
+func (e *Engine) expireUserBucket(userID string) {
+	value, ok := e.userBuckets.Load(userID)
+	if !ok {
+		return
+	}
+	bucket := value.(*tokenBucket)
+	if delay := bucket.untilFull(e.clock.Now()); delay > 0 {
+		e.wheel.afterFunc(delay, func() {
+			e.expireUserBucket(userID)
+		})
+		return
+	}
+	if len(e.users.clients(userID)) == 0 {
+		e.userBuckets.CompareAndDelete(userID, bucket)
+	}
+}
+
+// checkRateLimit applies the connection, user and command limits to a message
+// of command. It reports the action to take when a limit is exceeded.
+func (c *Client) checkRateLimit(command string, route *routeConfig) (RateLimitAction, bool) {
+	now := c.engine.clock.Now()
+	if action, ok := c.rateLimit.allow(now); !ok {
+		return action, false
+	}
+	if userID := c.UserID(); userID != "" && c.engine.userLimit.current.Load() != nil {
+		value, _ := c.engine.userBuckets.LoadOrStore(userID, newTokenBucket(c.engine.userLimit))
+		if action, ok := value.(*tokenBucket).allow(now); !ok {
+			return action, false
+		}
+	}
+	if route != nil && route.limit.current.Load() != nil {
+		bucket, ok := c.commandLimits[command]
+		if !ok {
+			bucket = newTokenBucket(route.limit)
+			c.commandLimits[command] = bucket
+		}
+		if action, ok := bucket.allow(now); !ok {
+			return action, false
+		}
+	}
+	return RateLimitReply, true
+}
+
+// rateLimited applies action to a message over its limit.
+func (c *Client) rateLimited(response ErrorResponder, action RateLimitAction) {
+	c.engine.stats.rateLimited.Add(1)
+	switch action {
+	case RateLimitReply:
+		c.handleError(response, ErrRateLimited, http.StatusTooManyRequests)
+	case RateLimitDisconnect:
+		c.closeWith(DisconnectReason{Kind: DisconnectRateLimited, Code: websocket.ClosePolicyViolation, Text: ErrRateLimited.Error()})
+	}
+}
+
+// SetRateLimit replaces the connection rate limit at runtime.
+func (c *Client) SetRateLimit(limit Limit) {
+	c.rateLimit.config.set(limit)
+}
+
+// SetUserRateLimit replaces the per-user rate limit, shared by all connections of a user, at runtime.
+func (e *Engine) SetUserRateLimit(limit Limit) {
+	e.userLimit.set(limit)
+}
+
+// SetCommandRateLimit replaces the per-connection rate limit of a registered command at runtime.
+func (e *Engine) SetCommandRateLimit(command string, limit Limit) error {
+	found := false
+	for _, config := range []*routeConfig{e.jsonRouter.config(command), e.protoRouter.config(command)} {
+		if config != nil {
+			config.setLimit(limit)
+			found = true
+		}
+	}
+	if !found {
+		return errCommandNotFound(command)
+	}
+	return nil
+}
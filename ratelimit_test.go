@@ -0,0 +1,230 @@
+package websocket
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	ws "github.com/gorilla/websocket"
+)
+
+// newRateLimitEngine returns an engine on clock with "ping" and "pong" commands
+// answering 200, opts apply to "ping".
+func newRateLimitEngine(t *testing.T, clock *testClock, engineOpts []EngineOption, opts ...RouteOption) *Engine {
+	e := newTestEngine(append([]EngineOption{WithClock(clock)}, engineOpts...)...)
+	t.Cleanup(func() {
+		_ = e.Shutdown(t.Context())
+	})
+	e.RegisterJsonRouter("ping", func(m *JsonMessage) {
+		m.Code = http.StatusOK
+	}, opts...)
+	e.RegisterJsonRouter("pong", func(m *JsonMessage) {
+		m.Code = http.StatusOK
+	})
+	return e
+}
+
+// call dispatches command on c and returns the codes of the replies.
+func call(c *Client, command string) (codes []int32) {
+	raw, _ := json.Marshal(&JsonMessage{RequestId: "1", SocketId: c.ID(), Command: command})
+	c.Dispatch(ws.TextMessage, raw)
+	for _, frame := range c.Drain() {
+		var reply JsonMessage
+		_ = json.Unmarshal(frame, &reply)
+		codes = append(codes, reply.Code)
+	}
+	return
+}
+
+// allowed dispatches command n times on c and counts the 200 replies.
+func allowed(c *Client, command string, n int) (ok int) {
+	for range n {
+		if codes := call(c, command); len(codes) == 1 && codes[0] == http.StatusOK {
+			ok++
+		}
+	}
+	return
+}
+
+func TestRateLimitRefillAndBurst(t *testing.T) {
+	clock := newTestClock()
+	e := newRateLimitEngine(t, clock, nil)
+	c := e.NewSyntheticClient(WithRateLimit(Limit{Rate: 2, Burst: 3}))
+
+	if n := allowed(c, "ping", 5); n != 3 {
+		t.Fatalf("%d messages allowed by a burst of 3", n)
+	}
+	if codes := call(c, "ping"); len(codes) != 1 || codes[0] != http.StatusTooManyRequests {
+		t.Fatalf("limited message answered %v", codes)
+	}
+	clock.advance(500 * time.Millisecond)
+	if n := allowed(c, "ping", 2); n != 1 {
+		t.Fatalf("%d messages allowed after half a second at 2/s", n)
+	}
+	clock.advance(time.Hour)
+	if n := allowed(c, "ping", 5); n != 3 {
+		t.Fatalf("%d messages allowed after a long idle, the burst is 3", n)
+	}
+	if n := e.Stats().RateLimited; n != 6 {
+		t.Fatalf("%d messages counted as rate limited", n)
+	}
+}
+
+func TestCommandRateLimit(t *testing.T) {
+	clock := newTestClock()
+	e := newRateLimitEngine(t, clock, nil, WithCommandRateLimit(Limit{Rate: 1, Burst: 1}))
+	c := e.NewSyntheticClient()
+	other := e.NewSyntheticClient()
+
+	if n := allowed(c, "ping", 3); n != 1 {
+		t.Fatalf("%d pings allowed", n)
+	}
+	if n := allowed(c, "pong", 3); n != 3 {
+		t.Fatalf("the ping limit held back %d pongs", 3-n)
+	}
+	if n := allowed(other, "ping", 1); n != 1 {
+		t.Fatal("the ping limit is shared across connections")
+	}
+	clock.advance(time.Second)
+	if n := allowed(c, "ping", 2); n != 1 {
+		t.Fatalf("%d pings allowed after a second", n)
+	}
+}
+
+func TestUserRateLimit(t *testing.T) {
+	clock := newTestClock()
+	e := newRateLimitEngine(t, clock, []EngineOption{WithUserRateLimit(Limit{Rate: 1, Burst: 4})})
+	first, second, other := e.NewSyntheticClient(), e.NewSyntheticClient(), e.NewSyntheticClient()
+	for c, user := range map[*Client]string{first: "alice", second: "alice", other: "bob"} {
+		if err := e.BindUser(c.ID(), user); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := allowed(first, "ping", 3) + allowed(second, "pong", 3); n != 4 {
+		t.Fatalf("%d messages allowed across two connections of a user with a burst of 4", n)
+	}
+	if n := allowed(other, "ping", 4); n != 4 {
+		t.Fatalf("another user got %d messages", n)
+	}
+	clock.advance(2 * time.Second)
+	if n := allowed(second, "ping", 1) + allowed(first, "ping", 2); n != 2 {
+		t.Fatalf("%d messages allowed after two seconds at 1/s", n)
+	}
+}
+
+func TestUserRateLimitSurvivesReconnect(t *testing.T) {
+	clock := newTestClock()
+	e := newRateLimitEngine(t, clock, []EngineOption{WithTimingWheel(time.Millisecond, 16),
+		WithUserRateLimit(Limit{Rate: 100, Burst: 2})})
+	connect := func() *Client {
+		c := e.NewSyntheticClient()
+		if err := e.BindUser(c.ID(), "alice"); err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	c := connect()
+	if n := allowed(c, "ping", 3); n != 2 {
+		t.Fatalf("%d messages allowed with a burst of 2", n)
+	}
+	_ = e.Kick(c.ID(), 0, "reconnect")
+	c = connect()
+	if n := allowed(c, "ping", 1); n != 0 {
+		t.Fatal("reconnecting refilled the user bucket")
+	}
+	_ = e.Kick(c.ID(), 0, "leave")
+
+	clock.advance(time.Second)
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if _, ok := e.userBuckets.Load("alice"); !ok {
+			return
+		}
+	}
+	t.Fatal("refilled bucket of a disconnected user kept")
+}
+
+func TestRateLimitActions(t *testing.T) {
+	clock := newTestClock()
+	reasons := make(chan DisconnectReason, 4)
+	e := newRateLimitEngine(t, clock, []EngineOption{WithOnDisconnect(func(c *Client, reason DisconnectReason, _ time.Duration) {
+		reasons <- reason
+	})})
+
+	c := e.NewSyntheticClient(WithRateLimit(Limit{Rate: 1, Burst: 1, Action: RateLimitReply}))
+	call(c, "ping")
+	if codes := call(c, "ping"); len(codes) != 1 || codes[0] != http.StatusTooManyRequests {
+		t.Fatalf("reply action answered %v", codes)
+	}
+
+	c = e.NewSyntheticClient(WithRateLimit(Limit{Rate: 1, Burst: 1, Action: RateLimitDrop}))
+	call(c, "ping")
+	if codes := call(c, "ping"); len(codes) != 0 {
+		t.Fatalf("drop action answered %v", codes)
+	}
+	select {
+	case reason := <-reasons:
+		t.Fatalf("disconnected by %s", reason)
+	default:
+	}
+
+	c = e.NewSyntheticClient(WithRateLimit(Limit{Rate: 1, Burst: 1, Action: RateLimitDisconnect}))
+	call(c, "ping")
+	call(c, "ping")
+	select {
+	case reason := <-reasons:
+		if reason.Kind != DisconnectRateLimited || reason.Code != ws.ClosePolicyViolation {
+			t.Fatalf("disconnect action closed with %s", reason)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("disconnect action kept the client")
+	}
+	if n := e.Stats().RateLimited; n != 3 {
+		t.Fatalf("%d messages counted as rate limited", n)
+	}
+}
+
+func TestSetRateLimit(t *testing.T) {
+	clock := newTestClock()
+	e := newRateLimitEngine(t, clock, nil)
+	c := e.NewSyntheticClient()
+	if err := e.BindUser(c.ID(), "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if n := allowed(c, "ping", 10); n != 10 {
+		t.Fatalf("%d messages allowed without limits", n)
+	}
+
+	c.SetRateLimit(Limit{Rate: 1, Burst: 2})
+	if n := allowed(c, "ping", 3); n != 2 {
+		t.Fatalf("%d messages allowed by a connection limit of 2", n)
+	}
+	c.SetRateLimit(Limit{})
+	if n := allowed(c, "ping", 3); n != 3 {
+		t.Fatalf("%d messages allowed once the connection limit is lifted", n)
+	}
+
+	e.SetUserRateLimit(Limit{Rate: 1, Burst: 1})
+	if n := allowed(c, "ping", 3); n != 1 {
+		t.Fatalf("%d messages allowed by a user limit of 1", n)
+	}
+	e.SetUserRateLimit(Limit{})
+
+	if err := e.SetCommandRateLimit("missing", Limit{Rate: 1}); err == nil {
+		t.Fatal("limit set on a missing command")
+	}
+	if err := e.SetCommandRateLimit("ping", Limit{Rate: 1, Burst: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if n := allowed(c, "ping", 3) + allowed(c, "pong", 3); n != 4 {
+		t.Fatalf("%d messages allowed with a ping limit of 1", n)
+	}
+	if err := e.SetCommandRateLimit("ping", Limit{Rate: 1, Burst: 3}); err != nil {
+		t.Fatal(err)
+	}
+	if n := allowed(c, "ping", 4); n != 3 {
+		t.Fatalf("%d pings allowed once raised to a burst of 3", n)
+	}
+}
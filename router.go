@@ -1,10 +1,14 @@
 package websocket
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"google.golang.org/protobuf/proto"
+	"net/http"
 	"sync"
+	"time"
 )
 
 type Func interface {
@@ -23,17 +27,48 @@ type Message interface {
 
 type Handler[T Message] func(message T)
 
+// ContextHandler is a Handler that receives the message context, it carries
+// the span of the message when tracing is enabled.
+type ContextHandler[T Message] func(ctx context.Context, message T)
+
 type Router[T Message] struct {
 	handlers sync.Map
 }
 
+// route is a registered handler with its per-command settings.
+type route[T Message] struct {
+	handler ContextHandler[T]
+	config  *routeConfig
+}
+
+// routeConfig holds the per-command settings set by RouteOption.
+type routeConfig struct {
+	limit       *limitConfig  // per-connection rate limit of the command
+	maxSize     int           // message size limit, 0 means the engine limit only
+	maxDataSize int           // Data size limit, 0 means the engine limit only
+	timeout     time.Duration // handler timeout, 0 means the engine timeout
+	async       bool          // handler runs off the read loop
+	stream      bool          // final reply of a stream, queued like its chunks
+}
+
+func newRouteConfig() *routeConfig {
+	return &routeConfig{
+		limit: &limitConfig{},
+	}
+}
+
+func (r *routeConfig) setLimit(limit Limit) {
+	r.limit.set(limit)
+}
+
 type JsonMessage struct {
-	RequestId string `json:"request_id" validate:"required"`
-	SocketId  string `json:"socket_id" validate:"required"`
-	Command   string `json:"command" validate:"required"`
-	Code      int32  `json:"code,omitempty"`
-	Message   string `json:"message,omitempty"`
-	Data      []byte `json:"data,omitempty"`
+	RequestId string            `json:"request_id" validate:"required"`
+	SocketId  string            `json:"socket_id" validate:"required"`
+	Command   string            `json:"command" validate:"required"`
+	Code      int32             `json:"code,omitempty"`
+	Message   string            `json:"message,omitempty"`
+	Data      []byte            `json:"data,omitempty"`
+	Metadata  map[string]string `json:"metadata,omitempty"` // e.g. trace context
 }
 
 func (j *JsonMessage) toBytes() []byte {
@@ -60,22 +95,50 @@ func (p *ProtoFuncWrapper) SetError(err error, code int32) {
 	p.ProtoMessage.Code = code
 }
 
+// handlerCode returns the code of the reply of a stream or upload handler: the
+// handler's, 500 with the error message when it failed without setting an
+// error code, else 200.
+func handlerCode(response ErrorResponder, code int32, err error) int32 {
+	switch {
+	case err != nil && code < http.StatusBadRequest:
+		response.SetError(err, http.StatusInternalServerError)
+		return http.StatusInternalServerError
+	case code == 0:
+		return http.StatusOK
+	}
+	return code
+}
+
 func NewRouter[T Message]() *Router[T] {
 	return &Router[T]{}
 }
 
-func (r *Router[T]) register(command string, handler Handler[T]) {
-	r.handlers.Store(command, handler)
+func (r *Router[T]) register(command string, handler ContextHandler[T], opts ...RouteOption) {
+	config := newRouteConfig()
+	for _, opt := range opts {
+		opt.apply(config)
+	}
+	r.handlers.Store(command, &route[T]{handler: handler, config: config})
 }
 
-func (r *Router[T]) get(command string) (handler Handler[T], err error) {
+func (r *Router[T]) get(command string) (handler ContextHandler[T], config *routeConfig, err error) {
 	value, ok := r.handlers.Load(command)
 	if !ok {
-		return
+		return nil, nil, errCommandNotFound(command)
 	}
-	handler, ok = value.(Handler[T])
+	entry, ok := value.(*route[T])
 	if !ok {
-		return nil, errors.New("handler type error")
+		return nil, nil, errors.New("handler type error")
 	}
-	return
+	return entry.handler, entry.config, nil
+}
+
+// config returns the settings of command, nil when it is not registered.
+func (r *Router[T]) config(command string) *routeConfig {
+	_, config, _ := r.get(command)
+	return config
+}
+
+func errCommandNotFound(command string) error {
+	return fmt.Errorf("command `%s` not found", command)
 }
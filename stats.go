@@ -0,0 +1,36 @@
+package websocket
+
+import "sync/atomic"
+
+// Stats is a snapshot of the engine counters.
+type Stats struct {
+	Connections   uint32 // currently registered clients
+	Dropped       uint64 // outbound messages dropped by backpressure policies
+	SlowConsumers uint64 // clients disconnected as slow consumers
+	RateLimited   uint64 // inbound messages over a rate limit
+	Oversized     uint64 // inbound messages over a size limit
+	Invalid       uint64 // inbound messages over the JSON depth or proto field limits
+	Rejected      uint64 // upgrade requests refused by admission control
+}
+
+type engineStats struct {
+	dropped       atomic.Uint64
+	slowConsumers atomic.Uint64
+	rateLimited   atomic.Uint64
+	oversized     atomic.Uint64
+	invalid       atomic.Uint64
+	rejected      atomic.Uint64
+}
+
+// Stats returns a snapshot of the engine counters.
+func (e *Engine) Stats() Stats {
+	return Stats{
+		Connections:   e.total.Load(),
+		Dropped:       e.stats.dropped.Load(),
+		SlowConsumers: e.stats.slowConsumers.Load(),
+		RateLimited:   e.stats.rateLimited.Load(),
+		Oversized:     e.stats.oversized.Load(),
+		Invalid:       e.stats.invalid.Load(),
+		Rejected:      e.stats.rejected.Load(),
+	}
+}
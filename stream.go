@@ -0,0 +1,151 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// StreamWindow is how many chunks of a stream may wait in the client queue,
+// Send blocks beyond it until the write loop catches up.
+const StreamWindow = 16
+
+// Stream frames carry the request id and command of the request, their kind
+// and sequence number are in the metadata. Chunks have code 206; the last
+// frame is the reply of the request, of kind StreamEnd, or StreamError when
+// the handler failed, numbered after the last chunk.
+const (
+	MetadataStream = "stream" // metadata key of the stream frame kind
+	MetadataSeq    = "seq"    // metadata key of the stream frame sequence number, from 1
+	StreamChunk    = "chunk"
+	StreamEnd      = "end"
+	StreamError    = "error"
+)
+
+// ErrStreamClosed is returned by Send once the handler of the stream has returned.
+var ErrStreamClosed = errors.New("stream is closed")
+
+// StreamHandler handles a streaming command: it sends chunks on stream, then
+// returns. The reply in request ends the stream, a returned error ends it
+// with an error frame.
+type StreamHandler[T any] func(ctx context.Context, request T, stream *Stream) error
+
+// Stream writes the chunks of the reply to one request.
+type Stream struct {
+	ctx       context.Context
+	client    *Client
+	requestID string
+	socketID  string
+	command   string
+	credits   chan struct{} // one per chunk queued and not yet handed to the write loop
+
+	mux    sync.Mutex
+	seq    int
+	closed bool
+}
+
+func newStream(ctx context.Context, requestID, socketID, command string) *Stream {
+	return &Stream{
+		ctx:       ctx,
+		client:    ClientFromContext(ctx),
+		requestID: requestID,
+		socketID:  socketID,
+		command:   command,
+		credits:   make(chan struct{}, StreamWindow),
+	}
+}
+
+// Send queues a chunk carrying data. It blocks while StreamWindow chunks are
+// queued, so a slow client holds the handler back instead of growing its
+// queue; it returns the context error when the request is done meanwhile.
+// Chunks are not subject to the DropNewest, DropOldest and Coalesce policies,
+// a stream never loses one of its chunks.
+func (s *Stream) Send(data []byte) error {
+	select {
+	case s.credits <- struct{}{}:
+	case <-s.ctx.Done():
+		return context.Cause(s.ctx)
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.closed {
+		<-s.credits
+		return ErrStreamClosed
+	}
+	s.seq++
+	f := frame{
+		data:     s.encode(data, s.seq),
+		command:  s.command,
+		priority: PriorityReply,
+		keep:     true,
+		done: func() {
+			<-s.credits
+		},
+	}
+	err := s.client.enqueue(f)
+	if err != nil { // not queued, give the credit back
+		<-s.credits
+	}
+	return err
+}
+
+// encode encodes a chunk for the client protocol.
+func (s *Stream) encode(data []byte, seq int) []byte {
+	metadata := map[string]string{MetadataStream: StreamChunk, MetadataSeq: strconv.Itoa(seq)}
+	if s.client.Protocol() == websocket.BinaryMessage {
+		return (&ProtoFuncWrapper{ProtoMessage: &ProtoMessage{RequestId: s.requestID, SocketId: s.socketID,
+			Command: s.command, Code: http.StatusPartialContent, Data: data, Metadata: metadata}}).toBytes()
+	}
+	return (&JsonMessage{RequestId: s.requestID, SocketId: s.socketID, Command: s.command,
+		Code: http.StatusPartialContent, Data: data, Metadata: metadata}).toBytes()
+}
+
+// end closes the stream and returns the metadata of the final reply.
+func (s *Stream) end(metadata map[string]string, err error) map[string]string {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.closed = true
+	if metadata == nil {
+		metadata = make(map[string]string, 2)
+	}
+	metadata[MetadataStream] = StreamEnd
+	if err != nil {
+		metadata[MetadataStream] = StreamError
+	}
+	metadata[MetadataSeq] = strconv.Itoa(s.seq + 1)
+	return metadata
+}
+
+// streamRoute marks the route of a stream, so that its final reply is never
+// dropped by the backpressure policy like its chunks.
+func streamRoute() RouteOption {
+	return routeOptionFunc(func(config *routeConfig) {
+		config.stream = true
+	})
+}
+
+// RegisterJsonStreamRouter registers a streaming command for JSON clients. It
+// runs off the read loop like a WithAsync command, so it can be cancelled.
+func (e *Engine) RegisterJsonStreamRouter(command string, handler StreamHandler[*JsonMessage], opts ...RouteOption) {
+	e.RegisterJsonContextRouter(command, func(ctx context.Context, message *JsonMessage) {
+		stream := newStream(ctx, message.RequestId, message.SocketId, message.Command)
+		err := handler(ctx, message, stream)
+		message.Metadata = stream.end(message.Metadata, err)
+		message.Code = handlerCode(message, message.Code, err)
+	}, append([]RouteOption{WithAsync(), streamRoute()}, opts...)...)
+}
+
+// RegisterProtoStreamRouter registers a streaming command for proto clients. It
+// runs off the read loop like a WithAsync command, so it can be cancelled.
+func (e *Engine) RegisterProtoStreamRouter(command string, handler StreamHandler[*ProtoMessage], opts ...RouteOption) {
+	e.RegisterProtoContextRouter(command, func(ctx context.Context, message *ProtoMessage) {
+		stream := newStream(ctx, message.RequestId, message.SocketId, message.Command)
+		err := handler(ctx, message, stream)
+		message.Metadata = stream.end(message.Metadata, err)
+		message.Code = handlerCode(&ProtoFuncWrapper{ProtoMessage: message}, message.Code, err)
+	}, append([]RouteOption{WithAsync(), streamRoute()}, opts...)...)
+}
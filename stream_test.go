@@ -0,0 +1,152 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	ws "github.com/gorilla/websocket"
+)
+
+func TestStream(t *testing.T) {
+	e := newTestEngine()
+	defer e.Shutdown(t.Context())
+	const chunks = 3 * StreamWindow
+	e.RegisterJsonStreamRouter("tail", func(ctx context.Context, request *JsonMessage, stream *Stream) error {
+		for i := range chunks {
+			if err := stream.Send([]byte(strconv.Itoa(i))); err != nil {
+				return err
+			}
+		}
+		if string(request.Data) == "fail" {
+			return errors.New("tail failed")
+		}
+		return nil
+	})
+
+	c := e.NewSyntheticClient()
+	for _, data := range []string{"ok", "fail"} {
+		request, _ := json.Marshal(&JsonMessage{RequestId: data, SocketId: c.ID(), Command: "tail", Data: []byte(data)})
+		c.Dispatch(ws.TextMessage, request)
+
+		deadline := time.Now().Add(time.Second)
+		for length, _, _ := c.QueueStats(); length < StreamWindow && time.Now().Before(deadline); length, _, _ = c.QueueStats() {
+			time.Sleep(time.Millisecond)
+		}
+		time.Sleep(10 * time.Millisecond)
+		if length, _, _ := c.QueueStats(); length != StreamWindow {
+			t.Fatalf("%d frames queued by a stream with a window of %d", length, StreamWindow)
+		}
+
+		var frames []JsonMessage
+		for deadline = time.Now().Add(time.Second); len(frames) <= chunks && time.Now().Before(deadline); {
+			for _, raw := range c.Drain() {
+				var frame JsonMessage
+				if err := json.Unmarshal(raw, &frame); err != nil {
+					t.Fatal(err)
+				}
+				frames = append(frames, frame)
+			}
+			time.Sleep(time.Millisecond)
+		}
+		if len(frames) != chunks+1 {
+			t.Fatalf("%d frames, want %d", len(frames), chunks+1)
+		}
+		for i, frame := range frames[:chunks] {
+			if frame.Code != http.StatusPartialContent || frame.RequestId != data || string(frame.Data) != strconv.Itoa(i) ||
+				frame.Metadata[MetadataStream] != StreamChunk || frame.Metadata[MetadataSeq] != strconv.Itoa(i+1) {
+				t.Fatalf("chunk %d: %+v", i, frame)
+			}
+		}
+		end, kind, code := frames[chunks], StreamEnd, int32(http.StatusOK)
+		if data == "fail" {
+			kind, code = StreamError, http.StatusInternalServerError
+		}
+		if end.Code != code || end.Metadata[MetadataStream] != kind || end.Metadata[MetadataSeq] != strconv.Itoa(chunks+1) {
+			t.Fatalf("end frame %+v", end)
+		}
+	}
+}
+
+func TestStreamKeepsChunksUnderDropPolicies(t *testing.T) {
+	e := newTestEngine()
+	defer e.Shutdown(t.Context())
+	const chunks = 2 * StreamWindow
+	e.RegisterJsonStreamRouter("tail", func(ctx context.Context, request *JsonMessage, stream *Stream) error {
+		for i := range chunks {
+			if err := stream.Send([]byte(strconv.Itoa(i))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	for _, policy := range []BackpressurePolicy{DropNewest, DropOldest, Coalesce} {
+		c := e.NewSyntheticClient(WithSendLimit(4), WithBackpressure(policy, 0))
+		request, _ := json.Marshal(&JsonMessage{RequestId: "1", SocketId: c.ID(), Command: "tail"})
+		c.Dispatch(ws.TextMessage, request)
+
+		var frames []JsonMessage
+		for deadline := time.Now().Add(5 * time.Second); len(frames) <= chunks && time.Now().Before(deadline); {
+			for _, raw := range c.Drain() {
+				var frame JsonMessage
+				if err := json.Unmarshal(raw, &frame); err != nil {
+					t.Fatal(err)
+				}
+				frames = append(frames, frame)
+			}
+			time.Sleep(time.Millisecond)
+		}
+		if len(frames) != chunks+1 {
+			t.Fatalf("policy %d: %d frames, want %d", policy, len(frames), chunks+1)
+		}
+		for i, frame := range frames {
+			if frame.Metadata[MetadataSeq] != strconv.Itoa(i+1) {
+				t.Fatalf("policy %d: frame %d has seq %s", policy, i, frame.Metadata[MetadataSeq])
+			}
+		}
+		if end := frames[chunks]; end.Code != http.StatusOK || end.Metadata[MetadataStream] != StreamEnd {
+			t.Fatalf("policy %d: end frame %+v", policy, end)
+		}
+		if _, _, dropped := c.QueueStats(); dropped != 0 {
+			t.Fatalf("policy %d: %d frames dropped", policy, dropped)
+		}
+	}
+}
+
+func TestStreamKeepsAbandonedReply(t *testing.T) {
+	e := newTestEngine()
+	defer e.Shutdown(t.Context())
+	release := make(chan struct{})
+	defer close(release)
+	e.RegisterJsonStreamRouter("tail", func(ctx context.Context, request *JsonMessage, stream *Stream) error {
+		for i := range StreamWindow {
+			if err := stream.Send([]byte(strconv.Itoa(i))); err != nil {
+				return err
+			}
+		}
+		<-release // ignores its context past the timeout
+		return nil
+	}, WithCommandTimeout(20*time.Millisecond))
+
+	for _, policy := range []BackpressurePolicy{DropNewest, DropOldest, Coalesce} {
+		c := e.NewSyntheticClient(WithSendLimit(4), WithBackpressure(policy, 0))
+		request, _ := json.Marshal(&JsonMessage{RequestId: "1", SocketId: c.ID(), Command: "tail"})
+		c.Dispatch(ws.TextMessage, request)
+
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+			if length, _, _ := c.QueueStats(); length > StreamWindow {
+				break
+			}
+		}
+		frames := c.Drain()
+		var last JsonMessage
+		if len(frames) != StreamWindow+1 || json.Unmarshal(frames[StreamWindow], &last) != nil || last.Code != http.StatusGatewayTimeout {
+			t.Fatalf("policy %d: %d frames, last %+v", policy, len(frames), last)
+		}
+	}
+}
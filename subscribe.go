@@ -1,7 +1,10 @@
 package websocket
 
 import (
+	"context"
 	"errors"
+	"net/http"
+	"sort"
 	"sync"
 )
 
@@ -19,7 +22,7 @@ type SystemMemory struct {
 
 func newSystemMemory() *SystemMemory {
 	return &SystemMemory{
-		subscribe: make(map[string][]string, RateLimit),
+		subscribe: make(map[string][]string),
 		mux:       new(sync.RWMutex),
 	}
 }
@@ -29,7 +32,7 @@ func (s *SystemMemory) Set(id, channel string) error {
 	defer s.mux.Unlock()
 
 	if _, ok := s.subscribe[channel]; !ok {
-		s.subscribe[channel] = make([]string, RateLimit)
+		s.subscribe[channel] = make([]string, 0, 1)
 	}
 	for _, v := range s.subscribe[channel] {
 		if v == id {
@@ -65,3 +68,79 @@ func (s *SystemMemory) Delete(id, channel string) error {
 	}
 	return errors.New("channel not found")
 }
+
+// ChannelLister is implemented by subscription stores that can list their
+// channels, it backs the admin channel listing.
+type ChannelLister interface {
+	// Channels returns the subscriber count of every channel.
+	Channels() (map[string]int, error)
+}
+
+// SubscriptionLister is implemented by subscription stores that can list the
+// channels of a connection.
+type SubscriptionLister interface {
+	Subscriptions(id string) (channels []string, err error)
+}
+
+func (s *SystemMemory) Channels() (map[string]int, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	channels := make(map[string]int, len(s.subscribe))
+	for channel, ids := range s.subscribe {
+		channels[channel] = len(ids)
+	}
+	return channels, nil
+}
+
+func (s *SystemMemory) Subscriptions(id string) (channels []string, err error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	for channel, ids := range s.subscribe {
+		for _, v := range ids {
+			if v == id {
+				channels = append(channels, channel)
+				break
+			}
+		}
+	}
+	sort.Strings(channels)
+	return
+}
+
+const (
+	SubscribeCommand   = "subscribe"   // command subscribing the connection to the channel named by Data
+	UnsubscribeCommand = "unsubscribe" // command unsubscribing the connection from the channel named by Data
+	MetadataChannel    = "channel"     // metadata key of the channel a published envelope was sent to
+)
+
+// RegisterSubscribeRouter registers SubscribeCommand and UnsubscribeCommand for
+// both codecs, letting connections manage their own subscriptions as the
+// client package does. Use route options to rate limit them.
+func (e *Engine) RegisterSubscribeRouter(opts ...RouteOption) {
+	e.RegisterJsonContextRouter(SubscribeCommand, func(ctx context.Context, message *JsonMessage) {
+		message.Code, message.Message = e.subscribeCommand(ctx, message.Data, e.Subscribe)
+	}, opts...)
+	e.RegisterJsonContextRouter(UnsubscribeCommand, func(ctx context.Context, message *JsonMessage) {
+		message.Code, message.Message = e.subscribeCommand(ctx, message.Data, e.Unsubscribe)
+	}, opts...)
+	e.RegisterProtoContextRouter(SubscribeCommand, func(ctx context.Context, message *ProtoMessage) {
+		message.Code, message.Message = e.subscribeCommand(ctx, message.Data, e.Subscribe)
+	}, opts...)
+	e.RegisterProtoContextRouter(UnsubscribeCommand, func(ctx context.Context, message *ProtoMessage) {
+		message.Code, message.Message = e.subscribeCommand(ctx, message.Data, e.Unsubscribe)
+	}, opts...)
+}
+
+// subscribeCommand applies action to the connection of ctx and the channel in data.
+func (e *Engine) subscribeCommand(ctx context.Context, data []byte, action func(id, channel string) error) (int32, string) {
+	client := ClientFromContext(ctx)
+	if client == nil || len(data) == 0 {
+		return http.StatusBadRequest, "channel is required"
+	}
+	if err := action(client.id, string(data)); err != nil {
+		return http.StatusInternalServerError, err.Error()
+	}
+	return http.StatusOK, Success
+}
@@ -0,0 +1,68 @@
+package websocket
+
+import (
+	"context"
+	"log/slog"
+)
+
+// syntheticAddr is the remote address of a client bound to no connection.
+type syntheticAddr struct{}
+
+func (syntheticAddr) Network() string {
+	return "synthetic"
+}
+
+func (syntheticAddr) String() string {
+	return "synthetic"
+}
+
+// NewSyntheticClient returns a client of the engine bound to no connection, to
+// test handlers without any network. It is registered like an upgraded client,
+// so it can subscribe and receive publishes, but it has no read or write loop:
+// Dispatch feeds it messages and Drain returns what it was sent. Kick it or shut
+// the engine down to release it.
+func (e *Engine) NewSyntheticClient(opts ...Option) *Client {
+	client := newClientWithOptions(nil, opts...)
+	client.engine = e
+	client.resetTime()
+	client.log = slog.New(&clientHandler{client: client, Handler: e.log.Handler()})
+	e.registerClient(client)
+	return client
+}
+
+// Dispatch handles a text or binary message as if the client had sent it: the
+// hooks, limits and routes run and the reply is queued before it returns, but
+// for WithAsync and stream commands, whose handlers reply later; Next waits for
+// those. It is meant for synthetic clients, an upgraded client is fed by its read loop.
+func (c *Client) Dispatch(messageType int, message []byte) {
+	c.receive(messageType, message)
+}
+
+// Drain removes and returns the queued outbound messages, in the order the write
+// loop would send them. It is meant for synthetic clients, an upgraded client is
+// drained by its write loop.
+func (c *Client) Drain() (messages [][]byte) {
+	for {
+		f, ok := c.queue.pop()
+		if !ok {
+			return
+		}
+		messages = append(messages, f.data)
+	}
+}
+
+// Next removes and returns the next queued outbound message, waiting for one
+// until ctx is done. It is meant for synthetic clients, to read the replies of
+// async and stream commands.
+func (c *Client) Next(ctx context.Context) ([]byte, error) {
+	for {
+		if f, ok := c.queue.pop(); ok {
+			return f.data, nil
+		}
+		select {
+		case <-c.queue.notify:
+		case <-ctx.Done():
+			return nil, context.Cause(ctx)
+		}
+	}
+}
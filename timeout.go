@@ -0,0 +1,112 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrHandlerTimeout is replied with 504 to a request whose handler outlived its timeout.
+	ErrHandlerTimeout = errors.New("handler timed out")
+	// ErrHandlerPanic is replied with 500 to a request whose handler panicked,
+	// the error hook gets it wrapped with the panic value.
+	ErrHandlerPanic = errors.New("handler panicked")
+)
+
+// handlerTimeout returns the timeout of route, the engine one unless the command sets its own.
+func (c *Client) handlerTimeout(route *routeConfig) time.Duration {
+	if route != nil && route.timeout > 0 {
+		return route.timeout
+	}
+	return c.engine.handlerTimeout
+}
+
+// invoke runs handler with a context derived from ctx. Without a timeout the
+// handler runs inline and invoke returns ErrRequestCancelled if the request was
+// cancelled meanwhile. With one it runs on its own goroutine and invoke gives
+// up once the context is done, the timeout passed, the request cancelled or
+// the client released, returning the cause without waiting: a hung handler
+// cannot stall the read loop, its reply is dropped. A handler panic is
+// returned as an ErrHandlerPanic error, or logged and passed to the error
+// hook by the handler goroutine once invoke has given up.
+func (c *Client) invoke(ctx context.Context, route *routeConfig, command string, handler func(ctx context.Context)) (err error) {
+	timeout := c.handlerTimeout(route)
+	if timeout <= 0 {
+		defer func() {
+			if r := recover(); r != nil {
+				err = panicError(r)
+			}
+		}()
+		handler(ctx)
+		if err := context.Cause(ctx); errors.Is(err, ErrRequestCancelled) {
+			return err
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeoutCause(ctx, timeout, ErrHandlerTimeout)
+	defer cancel()
+	var claimed atomic.Bool // set by whichever of invoke and the handler is done first
+	finished := make(chan any, 1)
+	go func() {
+		defer func() {
+			r := recover()
+			if claimed.CompareAndSwap(false, true) {
+				finished <- r
+			} else if r != nil {
+				err := panicError(r)
+				c.log.Error("abandoned handler panic", "command", command, "err", err)
+				c.engine.hooks.error(c, err, http.StatusInternalServerError)
+			}
+		}()
+		handler(ctx)
+	}()
+
+	select {
+	case r := <-finished:
+		if r != nil {
+			return panicError(r)
+		}
+		return nil
+	case <-ctx.Done():
+		if !claimed.CompareAndSwap(false, true) { // the handler returned meanwhile
+			if r := <-finished; r != nil {
+				return panicError(r)
+			}
+			return nil
+		}
+		return context.Cause(ctx)
+	}
+}
+
+// panicError wraps the value recovered from a handler panic.
+func panicError(r any) error {
+	return fmt.Errorf("%w: %v", ErrHandlerPanic, r)
+}
+
+// abandoned replies to a request invoke gave up on, a timeout with 504, a
+// cancelled request with StatusCancelled and a panic with 500. A released
+// client gets no reply. Like a final reply, it is kept for a stream route.
+func (c *Client) abandoned(route *routeConfig, response ErrorResponder, command string, err error) {
+	switch {
+	case errors.Is(err, ErrHandlerPanic):
+		c.log.Error("handler panic", "command", command, "err", err)
+		c.engine.hooks.error(c, err, http.StatusInternalServerError)
+		response.SetError(ErrHandlerPanic, http.StatusInternalServerError)
+	case errors.Is(err, ErrHandlerTimeout):
+		c.engine.logSampled(c.log, slog.LevelWarn, "handler timed out", "command", command)
+		c.engine.hooks.error(c, err, http.StatusGatewayTimeout)
+		response.SetError(err, http.StatusGatewayTimeout)
+	case errors.Is(err, ErrRequestCancelled):
+		c.engine.hooks.error(c, err, StatusCancelled)
+		response.SetError(err, StatusCancelled)
+	default:
+		return
+	}
+	c.reply(route, "", response.toBytes())
+}
@@ -0,0 +1,111 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	ws "github.com/gorilla/websocket"
+)
+
+func TestHandlerTimeout(t *testing.T) {
+	e := newTestEngine(WithHandlerTimeout(time.Hour))
+	defer e.Shutdown(t.Context())
+	hung := make(chan struct{})
+	defer close(hung)
+	e.RegisterJsonContextRouter("slow", func(ctx context.Context, m *JsonMessage) {
+		<-hung // ignores its context, like a stuck downstream call
+		m.Code = http.StatusOK
+	}, WithCommandTimeout(20*time.Millisecond))
+	e.RegisterJsonRouter("panic", func(m *JsonMessage) {
+		panic("boom")
+	})
+
+	c := e.NewSyntheticClient()
+	request := func(command string) []byte {
+		return []byte(`{"request_id":"1","socket_id":"` + c.ID() + `","command":"` + command + `"}`)
+	}
+	start := time.Now()
+	c.Dispatch(ws.TextMessage, request("slow"))
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("the read loop was held for %s", elapsed)
+	}
+	replies := c.Drain()
+	var reply JsonMessage
+	if len(replies) != 1 || json.Unmarshal(replies[0], &reply) != nil || reply.Code != http.StatusGatewayTimeout ||
+		reply.Command != "slow" || reply.RequestId != "1" {
+		t.Fatalf("timeout replied %q", replies)
+	}
+
+	c.Dispatch(ws.TextMessage, request("panic"))
+	replies = c.Drain()
+	if len(replies) != 1 || json.Unmarshal(replies[0], &reply) != nil || reply.Code != http.StatusInternalServerError ||
+		reply.RequestId != "1" || reply.Message != ErrHandlerPanic.Error() {
+		t.Fatalf("panic replied %q", replies)
+	}
+}
+
+func TestAbandonedHandlerPanic(t *testing.T) {
+	failures := make(chan error, 2)
+	e := newTestEngine(WithOnError(func(c *Client, err error, code int32) {
+		if code == http.StatusInternalServerError {
+			failures <- err
+		}
+	}))
+	defer e.Shutdown(t.Context())
+	release := make(chan struct{})
+	e.RegisterJsonRouter("late", func(m *JsonMessage) {
+		<-release
+		panic("late boom")
+	}, WithCommandTimeout(20*time.Millisecond))
+
+	c := e.NewSyntheticClient()
+	c.Dispatch(ws.TextMessage, []byte(`{"request_id":"1","socket_id":"`+c.ID()+`","command":"late"}`))
+	var reply JsonMessage
+	if replies := c.Drain(); len(replies) != 1 || json.Unmarshal(replies[0], &reply) != nil || reply.Code != http.StatusGatewayTimeout {
+		t.Fatalf("timeout replied %q", replies)
+	}
+	close(release)
+	select {
+	case err := <-failures:
+		if !errors.Is(err, ErrHandlerPanic) || !strings.Contains(err.Error(), "late boom") {
+			t.Fatalf("error hook got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the panic of the abandoned handler was lost")
+	}
+	if replies := c.Drain(); len(replies) != 0 {
+		t.Fatalf("abandoned handler replied %q", replies)
+	}
+}
+
+func TestHandlerCancelledOnDisconnect(t *testing.T) {
+	e := newTestEngine(WithHandlerTimeout(time.Hour))
+	defer e.Shutdown(t.Context())
+	started, cause := make(chan struct{}), make(chan error, 1)
+	e.RegisterJsonContextRouter("wait", func(ctx context.Context, m *JsonMessage) {
+		close(started)
+		<-ctx.Done()
+		cause <- context.Cause(ctx)
+	})
+
+	c := e.NewSyntheticClient()
+	dispatched := make(chan struct{})
+	go func() {
+		defer close(dispatched)
+		c.Dispatch(ws.TextMessage, []byte(`{"request_id":"1","socket_id":"`+c.ID()+`","command":"wait"}`))
+	}()
+	<-started
+	if err := e.Kick(c.ID(), 0, "bye"); err != nil {
+		t.Fatal(err)
+	}
+	var reason DisconnectReason
+	if err := <-cause; !errors.As(err, &reason) || reason.Kind != DisconnectKick {
+		t.Fatalf("handler cancelled with %v", err)
+	}
+	<-dispatched
+}
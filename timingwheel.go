@@ -0,0 +1,148 @@
+package websocket
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	WheelTick  = 100 * time.Millisecond // timing wheel tick
+	WheelSlots = 600                    // timing wheel slots, one revolution is WheelTick * WheelSlots
+)
+
+// timingWheel is a hashed timing wheel shared by every client of an Engine.
+// A single ticker drives all idle checks, ping scheduling and other delayed
+// client work, instead of one goroutine and one ticker per connection.
+type timingWheel struct {
+	mux   sync.Mutex
+	tick  time.Duration
+	slots []*timerTask // head of the task list of each slot
+	pos   int
+	stop  chan struct{}
+	once  sync.Once
+}
+
+// timerTask is a callback scheduled on a timingWheel.
+type timerTask struct {
+	wheel  *timingWheel
+	slot   int
+	rounds int
+	fn     func()
+	prev   *timerTask
+	next   *timerTask
+	active bool
+}
+
+func newTimingWheel(tick time.Duration, slots int) *timingWheel {
+	if tick <= 0 {
+		tick = WheelTick
+	}
+	if slots <= 0 {
+		slots = WheelSlots
+	}
+	return &timingWheel{
+		tick:  tick,
+		slots: make([]*timerTask, slots),
+		stop:  make(chan struct{}),
+	}
+}
+
+// run drives the wheel with the ticks of clock until close is called.
+func (w *timingWheel) run(clock Clock) {
+	ticks, stop := clock.NewTicker(w.tick)
+	defer stop()
+
+	for {
+		select {
+		case <-ticks:
+			w.advance()
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// close stops the wheel, pending tasks are never fired.
+func (w *timingWheel) close() {
+	w.once.Do(func() {
+		close(w.stop)
+	})
+}
+
+// afterFunc schedules fn to run on the wheel goroutine after delay.
+// fn must not block; hand off blocking work to another goroutine.
+func (w *timingWheel) afterFunc(delay time.Duration, fn func()) *timerTask {
+	ticks := int((delay + w.tick - 1) / w.tick)
+	if ticks < 1 {
+		ticks = 1
+	}
+
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	task := &timerTask{
+		wheel:  w,
+		slot:   (w.pos + ticks) % len(w.slots),
+		rounds: (ticks - 1) / len(w.slots),
+		fn:     fn,
+		active: true,
+	}
+	task.next = w.slots[task.slot]
+	if task.next != nil {
+		task.next.prev = task
+	}
+	w.slots[task.slot] = task
+	return task
+}
+
+// advance moves the wheel one tick forward and fires the expired tasks.
+func (w *timingWheel) advance() {
+	var expired []*timerTask
+
+	w.mux.Lock()
+	w.pos = (w.pos + 1) % len(w.slots)
+	for task := w.slots[w.pos]; task != nil; {
+		next := task.next
+		if task.rounds > 0 {
+			task.rounds--
+		} else {
+			w.unlink(task)
+			expired = append(expired, task)
+		}
+		task = next
+	}
+	w.mux.Unlock()
+
+	for _, task := range expired {
+		task.fn()
+	}
+}
+
+// unlink removes task from its slot, the caller must hold w.mux.
+func (w *timingWheel) unlink(task *timerTask) {
+	if task.prev != nil {
+		task.prev.next = task.next
+	} else {
+		w.slots[task.slot] = task.next
+	}
+	if task.next != nil {
+		task.next.prev = task.prev
+	}
+	task.prev, task.next = nil, nil
+	task.active = false
+}
+
+// stop cancels the task, it reports whether the task was still pending.
+func (t *timerTask) stop() bool {
+	if t == nil {
+		return false
+	}
+	t.wheel.mux.Lock()
+	defer t.wheel.mux.Unlock()
+
+	if !t.active {
+		return false
+	}
+	t.wheel.unlink(t)
+	return true
+}
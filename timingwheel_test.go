@@ -0,0 +1,105 @@
+package websocket
+
+import (
+	"runtime"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestTimingWheelFiresAndStops(t *testing.T) {
+	wheel := newTimingWheel(time.Millisecond, 8)
+	go wheel.run(wallClock{})
+	defer wheel.close()
+
+	fired := make(chan struct{})
+	wheel.afterFunc(20*time.Millisecond, func() { close(fired) }) // spans several rounds
+
+	var stopped atomic.Bool
+	task := wheel.afterFunc(5*time.Millisecond, func() { stopped.Store(true) })
+	if !task.stop() {
+		t.Fatal("expected pending task to stop")
+	}
+
+	select {
+	case <-fired:
+	case <-time.After(time.Second):
+		t.Fatal("task did not fire")
+	}
+	if stopped.Load() {
+		t.Fatal("stopped task fired")
+	}
+	if task.stop() {
+		t.Fatal("stop reported a pending task twice")
+	}
+}
+
+const benchClients = 100000
+
+// cpuTime returns the user+system CPU time consumed by the process.
+func cpuTime() time.Duration {
+	var usage syscall.Rusage
+	_ = syscall.Getrusage(syscall.RUSAGE_SELF, &usage)
+	return time.Duration(usage.Utime.Nano() + usage.Stime.Nano())
+}
+
+// reportScale records heap growth per client and CPU burnt while idling for one second.
+func reportScale(b *testing.B, setup func() func()) {
+	for i := 0; i < b.N; i++ {
+		var before, after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+
+		teardown := setup()
+		runtime.ReadMemStats(&after)
+		start := cpuTime()
+		time.Sleep(time.Second)
+		b.ReportMetric(float64(cpuTime()-start)/float64(time.Millisecond), "cpu-ms/s")
+		b.ReportMetric(float64(after.HeapAlloc-before.HeapAlloc)/benchClients, "heap-B/client")
+		teardown()
+	}
+}
+
+// BenchmarkHeartbeatTickers is the previous model: one goroutine and ticker per client.
+func BenchmarkHeartbeatTickers(b *testing.B) {
+	reportScale(b, func() func() {
+		done := make(chan struct{})
+		for i := 0; i < benchClients; i++ {
+			go func() {
+				ticker := time.NewTicker(200 * time.Millisecond)
+				defer ticker.Stop()
+				for {
+					select {
+					case <-ticker.C:
+					case <-done:
+						return
+					}
+				}
+			}()
+		}
+		return func() { close(done) }
+	})
+}
+
+// BenchmarkHeartbeatTimingWheel schedules the same checks on a shared timing wheel.
+func BenchmarkHeartbeatTimingWheel(b *testing.B) {
+	reportScale(b, func() func() {
+		wheel := newTimingWheel(WheelTick, WheelSlots)
+		go wheel.run(wallClock{})
+		for i := 0; i < benchClients; i++ {
+			var check func()
+			check = func() { wheel.afterFunc(200*time.Millisecond, check) }
+			wheel.afterFunc(200*time.Millisecond, check)
+		}
+		return wheel.close
+	})
+}
+
+func BenchmarkTimingWheelSchedule(b *testing.B) {
+	wheel := newTimingWheel(WheelTick, WheelSlots)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		wheel.afterFunc(time.Minute, func() {}).stop()
+	}
+}
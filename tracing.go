@@ -0,0 +1,76 @@
+package websocket
+
+import (
+	"context"
+	"fmt"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	"net/http"
+)
+
+// TracerName is the instrumentation scope of the engine spans.
+const TracerName = "github.com/gin-generator/websocket"
+
+// noopTracer is the engine default, tracing is off until WithTracerProvider.
+var noopTracer = noop.NewTracerProvider().Tracer(TracerName)
+
+// startSpan starts the span of an inbound message, continuing the trace
+// propagated in the message metadata if any. The returned context derives
+// from the client, it is done once the client is released.
+func (c *Client) startSpan(command, requestID string, metadata map[string]string) (context.Context, trace.Span) {
+	ctx := c.engine.ExtractTrace(c, metadata)
+	return c.engine.tracer.Start(ctx, command,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("websocket.command", command),
+			attribute.String("websocket.socket_id", c.id),
+			attribute.String("websocket.request_id", requestID),
+			attribute.String("websocket.codec", codec(c.Protocol())),
+		),
+	)
+}
+
+// spanError marks span as failed with err.
+func spanError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// spanReply records the reply code set by the handler, error codes fail the span.
+func spanReply(span trace.Span, code int32, message string) {
+	if code == 0 {
+		return
+	}
+	span.SetAttributes(attribute.Int("websocket.code", int(code)))
+	if code >= http.StatusBadRequest {
+		span.SetStatus(codes.Error, message)
+	}
+}
+
+// finishSpan ends span, a handler panic unwinding through it fails the span
+// and is raised again.
+func finishSpan(span trace.Span) {
+	if r := recover(); r != nil {
+		spanError(span, fmt.Errorf("handler panic: %v", r))
+		span.End()
+		panic(r)
+	}
+	span.End()
+}
+
+// InjectTrace writes the trace context of ctx into message metadata, e.g. to
+// carry it across a broker relaying messages between engines.
+func (e *Engine) InjectTrace(ctx context.Context, metadata map[string]string) {
+	e.propagator.Inject(ctx, propagation.MapCarrier(metadata))
+}
+
+// ExtractTrace returns ctx carrying the trace context found in message metadata.
+func (e *Engine) ExtractTrace(ctx context.Context, metadata map[string]string) context.Context {
+	if len(metadata) == 0 {
+		return ctx
+	}
+	return e.propagator.Extract(ctx, propagation.MapCarrier(metadata))
+}
@@ -0,0 +1,117 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	ws "github.com/gorilla/websocket"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTracingMessageSpan(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	e := newTestEngine(WithTracerProvider(provider))
+
+	handled := make(chan trace.SpanContext, 1)
+	e.RegisterJsonContextRouter("echo", func(ctx context.Context, msg *JsonMessage) {
+		handled <- trace.SpanContextFromContext(ctx)
+	})
+	url, stop := dialAdmission(t, e)
+	defer stop()
+
+	conn, _, err := ws.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_, _, _ = conn.ReadMessage()
+
+	const parent = "00-4bf92f3577b34da6a3ce929b0e0e4736-00f067aa0ba902b7-01"
+	err = conn.WriteJSON(JsonMessage{RequestId: "r1", SocketId: "s", Command: "echo", Metadata: map[string]string{"traceparent": parent}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = conn.ReadMessage(); err != nil {
+		t.Fatal(err)
+	}
+
+	sc := <-handled
+	if sc.TraceID().String() != "4bf92f3577b34da6a3ce929b0e0e4736" {
+		t.Errorf("handler trace id = %s, want the propagated one", sc.TraceID())
+	}
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(spans))
+	}
+	span := spans[0]
+	if span.Name != "echo" || span.SpanKind != trace.SpanKindServer {
+		t.Errorf("span %q kind %v", span.Name, span.SpanKind)
+	}
+	if span.Parent.SpanID().String() != "00f067aa0ba902b7" {
+		t.Errorf("parent span id = %s", span.Parent.SpanID())
+	}
+	attrs := map[string]string{}
+	for _, kv := range span.Attributes {
+		attrs[string(kv.Key)] = kv.Value.Emit()
+	}
+	for key, want := range map[string]string{
+		"websocket.command":    "echo",
+		"websocket.request_id": "r1",
+		"websocket.codec":      "json",
+	} {
+		if attrs[key] != want {
+			t.Errorf("%s = %q, want %q", key, attrs[key], want)
+		}
+	}
+	if attrs["websocket.socket_id"] == "" {
+		t.Error("missing socket id attribute")
+	}
+}
+
+func TestTracingPublishPropagates(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	e := newTestEngine(WithTracerProvider(provider))
+	url, stop := dialAdmission(t, e)
+	defer stop()
+
+	conn, _, err := ws.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var greeting JsonMessage
+	if err = conn.ReadJSON(&greeting); err != nil {
+		t.Fatal(err)
+	}
+	if err = e.Subscribe(greeting.SocketId, "news"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "request")
+	if err = e.PublishMessageContext(ctx, "news", "headline", []byte(`"hi"`)); err != nil {
+		t.Fatal(err)
+	}
+	parent.End()
+
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msg JsonMessage
+	if err = json.Unmarshal(data, &msg); err != nil {
+		t.Fatal(err)
+	}
+	publish := exporter.GetSpans()[0]
+	if publish.Name != "publish news" || publish.Parent.SpanID() != parent.SpanContext().SpanID() {
+		t.Fatalf("unexpected publish span %q parent %s", publish.Name, publish.Parent.SpanID())
+	}
+	sc := trace.SpanContextFromContext(e.ExtractTrace(context.Background(), msg.Metadata))
+	if sc.TraceID() != parent.SpanContext().TraceID() || sc.SpanID() != publish.SpanContext.SpanID() {
+		t.Errorf("metadata %v does not carry the publish span", msg.Metadata)
+	}
+}
@@ -2,49 +2,152 @@ package websocket
 
 import (
 	"errors"
-	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
+	"log/slog"
+	"net"
 	"net/http"
+	"strconv"
+	"time"
 )
 
-func Connect(engine *Engine, opts ...Option) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		err := upgrade(c, engine, opts...)
-		if err != nil {
-			c.Writer.WriteHeader(http.StatusInternalServerError)
-			_, err = c.Writer.Write([]byte(err.Error()))
-			if err != nil {
-				return
-			}
-		}
+// Subprotocols a client may ask for to pick its codec before the connected
+// greeting; without one the codec follows the type of the frames it sends.
+const (
+	SubprotocolJSON  = "json"
+	SubprotocolProto = "proto"
+)
+
+// ServeHTTP upgrades the request to a connection of the engine, with the
+// client options of WithClientOptions. The engine is an http.Handler, so it
+// plugs into net/http and the routers built on it, e.g. chi or gorilla/mux.
+func (e *Engine) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.serve(w, r, e.endpoint)
+}
+
+// Handler returns an http.Handler upgrading requests with the client options
+// opts, applied after those of WithClientOptions. Endpoint configures more.
+func (e *Engine) Handler(opts ...Option) http.Handler {
+	return e.Endpoint(WithEndpointClientOptions(opts...))
+}
+
+// serve upgrades r, answering a refused upgrade itself; a failed handshake has
+// already been answered by the upgrader.
+func (e *Engine) serve(w http.ResponseWriter, r *http.Request, ep *endpoint) {
+	var refused *admissionError
+	if err := upgrade(w, r, e, ep); errors.As(err, &refused) {
+		e.stats.rejected.Add(1)
+		e.logSampled(e.log, slog.LevelWarn, "connection refused", "remote_addr", r.RemoteAddr,
+			"status", refused.status, "err", refused.err)
+		refused.write(w)
 	}
 }
 
 // upgrade websocket connection
-func upgrade(c *gin.Context, engine *Engine, opts ...Option) (err error) {
-	if engine.total.Load() == engine.maxConn {
-		return errors.New("websocket service connections exceeded the upper limit")
+func upgrade(w http.ResponseWriter, r *http.Request, engine *Engine, ep *endpoint) (err error) {
+	ip := engine.clientIP(r)
+	userID, err := engine.admit(r, ip, ep.userResolver)
+	if err != nil {
+		return
 	}
 
-	conn, err := (&websocket.Upgrader{
-		ReadBufferSize:  engine.readBufferSize,
-		WriteBufferSize: engine.writeBufferSize,
-		CheckOrigin: func(r *http.Request) bool {
+	checkOrigin := ep.checkOrigin
+	if checkOrigin == nil {
+		checkOrigin = func(r *http.Request) bool {
 			return true
-		},
-	}).Upgrade(c.Writer, c.Request, nil)
+		}
+	}
+	conn, err := (&websocket.Upgrader{
+		ReadBufferSize:  ep.readBufferSize,
+		WriteBufferSize: ep.writeBufferSize,
+		Subprotocols:    ep.subprotocols(),
+		CheckOrigin:     checkOrigin,
+	}).Upgrade(w, r, nil)
 
 	if err != nil {
+		engine.unreserve(ip)
 		return
 	}
 
-	client := newClientWithOptions(conn, opts...)
+	client := newClientWithOptions(conn, ep.clientOptions...)
 	client.engine = engine
+	client.endpoint = ep
+	client.resetTime()
+	if batch, _ := strconv.ParseBool(r.URL.Query().Get(BatchQuery)); !batch {
+		client.batch = nil // batches only go to clients asking for them
+	}
+	if conn.Subprotocol() == SubprotocolProto || !ep.allowsProtocol(websocket.TextMessage) {
+		client.protocol.Store(websocket.BinaryMessage)
+	}
+	client.log = slog.New(&clientHandler{client: client, Handler: engine.log.Handler()})
+	client.ip = ip
+	if userID != "" {
+		client.SetUser(userID)
+	}
+	if err = engine.hooks.connect(client, r); err == nil {
+		err = ep.connect(client, r)
+	}
+	if err != nil {
+		engine.unreserve(ip)
+		rejectConn(conn, websocket.ClosePolicyViolation, err.Error())
+		return nil
+	}
+
+	var evicted []*Client
+	if userID = client.UserID(); userID != "" {
+		if evicted, err = engine.users.bind(client, userID); err != nil {
+			engine.unreserve(ip)
+			rejectConn(conn, websocket.ClosePolicyViolation, err.Error())
+			return nil
+		}
+	}
+
+	if !engine.track(2) {
+		engine.users.unbind(client)
+		engine.unreserve(ip)
+		rejectConn(conn, websocket.CloseGoingAway, engine.reconnectHint)
+		return nil
+	}
+
+	if ep.maxMessageSize > 0 {
+		conn.SetReadLimit(ep.maxMessageSize)
+	}
+	conn.SetPongHandler(func(string) error {
+		client.setLastTime(engine.clock.Now().Unix())
+		return nil
+	})
+	conn.SetPingHandler(func(data string) error {
+		client.setLastTime(engine.clock.Now().Unix())
+		err := conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(WriteWait))
+		if errors.Is(err, websocket.ErrCloseSent) {
+			return nil
+		}
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return nil
+		}
+		return err
+	})
+	client.queue.depth = engine.metrics.QueueDepth
 	engine.registerClient(client)
+	engine.metrics.ConnectionOpened()
+	client.log.Info("connected")
 	go client.read()
 	go client.write()
-	go client.heartbeat()
+	client.scheduleHeartbeat()
+	client.schedulePing()
+	if engine.isClosing() {
+		client.closeWith(engine.shutdownReason())
+	}
+	for _, old := range evicted {
+		old.closeWith(evictReason())
+	}
 
 	client.firstMessage()
 	return nil
 }
+
+// rejectConn closes an upgraded connection that never became a registered client.
+func rejectConn(conn *websocket.Conn, code int, text string) {
+	_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, text), time.Now().Add(WriteWait))
+	_ = conn.Close()
+}
@@ -0,0 +1,43 @@
+package websocket
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	ws "github.com/gorilla/websocket"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestServeHTTP(t *testing.T) {
+	e := newTestEngine(WithClientOptions(WithProtocol(ws.BinaryMessage)))
+	defer e.Shutdown(t.Context())
+	mux := http.NewServeMux()
+	mux.Handle("/ws", e)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	conn, _, err := ws.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	messageType, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var greeting ProtoMessage
+	if messageType != ws.BinaryMessage || proto.Unmarshal(data, &greeting) != nil || greeting.Command != Connected {
+		t.Fatalf("greeting %d %q", messageType, data)
+	}
+
+	resp, err := http.Get(srv.URL + "/ws")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("plain request answered %d", resp.StatusCode)
+	}
+}
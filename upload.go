@@ -0,0 +1,531 @@
+package websocket
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/satori/go.uuid"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Upload defaults.
+const (
+	UploadMaxSize      = 64 << 20         // largest upload accepted
+	UploadTTL          = 10 * time.Minute // time an unfinished upload survives without a chunk, and a finished one is remembered
+	UploadMaxPerClient = 4                // unfinished uploads of a command a client may have
+	UploadMaxOpen      = 1024             // unfinished uploads of a command across the engine
+)
+
+// Upload frames carry the command of the upload and their kind in the
+// metadata. An open frame declares the size, and optionally the hex sha256 of
+// the whole upload; its other metadata are handed to the handler. It is
+// answered with the upload id and the offset to send from: 0 for a new upload,
+// or how far an upload opened again with its id got, to resume it after a
+// reconnect. Chunk frames carry the upload id and their offset, their Data is
+// appended; a chunk at the wrong offset is refused with 409 and the expected
+// offset. The chunk completing the upload is answered by the handler; that
+// answer is kept for the upload ttl, a retry of the chunk gets it again and
+// an open frame of the finished upload gets its size as the offset.
+const (
+	MetadataUpload   = "upload"    // metadata key of the upload frame kind
+	MetadataUploadID = "upload_id" // metadata key of the upload id
+	MetadataOffset   = "offset"    // metadata key of the chunk offset, or of the next one in replies
+	MetadataSize     = "size"      // metadata key of the upload size
+	MetadataChecksum = "sha256"    // metadata key of the hex sha256 of the upload, in either case
+	UploadOpen       = "open"
+	UploadChunk      = "chunk"
+)
+
+var (
+	ErrUploadNotFound = errors.New("upload not found")
+	ErrUploadTooLarge = errors.New("upload too large")
+	ErrUploadOffset   = errors.New("chunk offset mismatch")
+	ErrUploadChecksum = errors.New("upload checksum mismatch")
+	ErrTooManyUploads = errors.New("too many uploads in progress")
+)
+
+type (
+	UploadOption interface {
+		apply(*uploadConfig)
+	}
+	uploadOptionFunc func(*uploadConfig)
+)
+
+func (u uploadOptionFunc) apply(config *uploadConfig) {
+	u(config)
+}
+
+type uploadConfig struct {
+	maxSize      int64
+	ttl          time.Duration
+	maxOwned     int // unfinished uploads per client, 0 means unlimited
+	maxOpen      int // unfinished uploads across the engine, 0 means unlimited
+	spool        bool
+	tempDir      string
+	routeOptions []RouteOption
+
+	mux   sync.Mutex
+	open  int
+	owned map[string]int // client -> unfinished uploads
+}
+
+// WithUploadMaxSize limits the size of the uploads of the command.
+func WithUploadMaxSize(size int64) UploadOption {
+	return uploadOptionFunc(func(config *uploadConfig) {
+		config.maxSize = size
+	})
+}
+
+// WithUploadTTL sets how long an unfinished upload waits for its next chunk,
+// e.g. while its client reconnects, before it is discarded.
+func WithUploadTTL(ttl time.Duration) UploadOption {
+	return uploadOptionFunc(func(config *uploadConfig) {
+		config.ttl = ttl
+	})
+}
+
+// WithMaxUploads limits the unfinished uploads of the command, perClient for
+// each client and total across the engine, UploadMaxPerClient and
+// UploadMaxOpen by default; an open frame over a limit is answered with 429.
+// A client is its user when authenticated, else its connection; 0 removes a limit.
+func WithMaxUploads(perClient, total int) UploadOption {
+	return uploadOptionFunc(func(config *uploadConfig) {
+		config.maxOwned, config.maxOpen = perClient, total
+	})
+}
+
+// WithUploadTempFile reassembles the uploads in temporary files of dir rather
+// than in memory, the default directory for temporary files when dir is empty.
+func WithUploadTempFile(dir string) UploadOption {
+	return uploadOptionFunc(func(config *uploadConfig) {
+		config.spool, config.tempDir = true, dir
+	})
+}
+
+// WithUploadRouteOptions sets the route options of the command, e.g. its rate
+// limit or the timeout of the handler.
+func WithUploadRouteOptions(opts ...RouteOption) UploadOption {
+	return uploadOptionFunc(func(config *uploadConfig) {
+		config.routeOptions = append(config.routeOptions, opts...)
+	})
+}
+
+// UploadHandler handles a complete upload. request is the chunk completing it,
+// without its data; the reply in it answers that chunk, a returned error
+// answers it with 500 unless the handler set an error code.
+type UploadHandler[T any] func(ctx context.Context, request T, upload *Upload) error
+
+// Upload is a complete upload, valid until its handler returns.
+type Upload struct {
+	ID       string
+	Command  string
+	Size     int64
+	Metadata map[string]string // metadata of the open frame
+	Reader   io.Reader         // content of the upload
+}
+
+// upload is an upload in progress, kept by the engine so that it survives the
+// connection it was opened on, then kept finished for its ttl so that the
+// chunk completing it can be retried.
+type upload struct {
+	Upload
+	userID   string
+	owner    string // client the upload counts against
+	checksum string
+	hash     hash.Hash
+	buffer   uploadBuffer
+	config   *uploadConfig
+	finished chan struct{} // closed once result is set
+
+	mux    sync.Mutex
+	offset int64
+	active time.Time // time of the last frame, or of the completion
+	done   bool      // complete or given up, no more chunks are taken
+	result *uploadResult
+}
+
+// uploadResult is the reply to the chunk completing an upload.
+type uploadResult struct {
+	code     int32
+	message  string
+	data     []byte
+	metadata map[string]string
+}
+
+// uploadBuffer stores the content of an upload while it is reassembled.
+type uploadBuffer interface {
+	io.Writer
+	reader() (io.Reader, error)
+	close() error
+}
+
+type memoryBuffer struct {
+	bytes.Buffer
+}
+
+func (b *memoryBuffer) reader() (io.Reader, error) {
+	return bytes.NewReader(b.Bytes()), nil
+}
+
+func (b *memoryBuffer) close() error {
+	return nil
+}
+
+type fileBuffer struct {
+	*os.File
+}
+
+func (b fileBuffer) reader() (io.Reader, error) {
+	_, err := b.Seek(0, io.SeekStart)
+	return b.File, err
+}
+
+func (b fileBuffer) close() error {
+	err := b.File.Close()
+	if removeErr := os.Remove(b.Name()); err == nil {
+		err = removeErr
+	}
+	return err
+}
+
+// RegisterJsonUploadRouter registers a chunked upload command for JSON clients.
+func (e *Engine) RegisterJsonUploadRouter(command string, handler UploadHandler[*JsonMessage], opts ...UploadOption) {
+	config := newUploadConfig(opts...)
+	e.RegisterJsonContextRouter(command, func(ctx context.Context, message *JsonMessage) {
+		u, replay, code, metadata, err := e.receiveUpload(ctx, message.Command, config, message.Metadata, message.Data)
+		message.Data, message.Metadata = nil, metadata
+		switch {
+		case err != nil:
+			message.SetError(err, code)
+			return
+		case replay != nil:
+			message.Code, message.Message, message.Data, message.Metadata = replay.code, replay.message, replay.data, replay.metadata
+			return
+		case u == nil:
+			message.Code, message.Message = code, Success
+			return
+		}
+		defer e.discardUpload(u)
+		result := &uploadResult{code: http.StatusInternalServerError, message: ErrHandlerPanic.Error()}
+		defer func() {
+			u.complete(result)
+		}()
+		err = handler(ctx, message, &u.Upload)
+		message.Code = handlerCode(message, message.Code, err)
+		message.Metadata = uploadMetadata(message.Metadata, metadata)
+		result = &uploadResult{code: message.Code, message: message.Message, data: message.Data, metadata: message.Metadata}
+	}, config.routeOptions...)
+}
+
+// RegisterProtoUploadRouter registers a chunked upload command for proto clients.
+func (e *Engine) RegisterProtoUploadRouter(command string, handler UploadHandler[*ProtoMessage], opts ...UploadOption) {
+	config := newUploadConfig(opts...)
+	e.RegisterProtoContextRouter(command, func(ctx context.Context, message *ProtoMessage) {
+		u, replay, code, metadata, err := e.receiveUpload(ctx, message.Command, config, message.Metadata, message.Data)
+		message.Data, message.Metadata = nil, metadata
+		response := &ProtoFuncWrapper{ProtoMessage: message}
+		switch {
+		case err != nil:
+			response.SetError(err, code)
+			return
+		case replay != nil:
+			message.Code, message.Message, message.Data, message.Metadata = replay.code, replay.message, replay.data, replay.metadata
+			return
+		case u == nil:
+			message.Code, message.Message = code, Success
+			return
+		}
+		defer e.discardUpload(u)
+		result := &uploadResult{code: http.StatusInternalServerError, message: ErrHandlerPanic.Error()}
+		defer func() {
+			u.complete(result)
+		}()
+		err = handler(ctx, message, &u.Upload)
+		message.Code = handlerCode(response, message.Code, err)
+		message.Metadata = uploadMetadata(message.Metadata, metadata)
+		result = &uploadResult{code: message.Code, message: message.Message, data: message.Data, metadata: message.Metadata}
+	}, config.routeOptions...)
+}
+
+func newUploadConfig(opts ...UploadOption) *uploadConfig {
+	config := &uploadConfig{maxSize: UploadMaxSize, ttl: UploadTTL, maxOwned: UploadMaxPerClient, maxOpen: UploadMaxOpen,
+		owned: make(map[string]int)}
+	for _, opt := range opts {
+		opt.apply(config)
+	}
+	return config
+}
+
+// uploadMetadata adds the upload id and offset of the reply to the metadata
+// the handler replied with.
+func uploadMetadata(replied, upload map[string]string) map[string]string {
+	if replied == nil {
+		return upload
+	}
+	for key, value := range upload {
+		replied[key] = value
+	}
+	return replied
+}
+
+// receiveUpload handles an upload frame and returns the code and metadata of
+// its reply, and the upload once its last chunk is in. A retried last chunk
+// returns the reply it got the first time instead.
+func (e *Engine) receiveUpload(ctx context.Context, command string, config *uploadConfig,
+	metadata map[string]string, data []byte) (*upload, *uploadResult, int32, map[string]string, error) {
+	var userID, owner string
+	if client := ClientFromContext(ctx); client != nil {
+		userID, owner = client.UserID(), client.UserID()
+		if owner == "" {
+			owner = client.ID()
+		}
+	}
+
+	kind := metadata[MetadataUpload]
+	if kind == UploadOpen && metadata[MetadataUploadID] == "" {
+		u, code, err := e.openUpload(command, userID, owner, config, metadata)
+		if err != nil {
+			return nil, nil, code, nil, err
+		}
+		return nil, nil, http.StatusOK, u.reply(kind, 0), nil
+	}
+	if kind != UploadOpen && kind != UploadChunk {
+		return nil, nil, http.StatusBadRequest, nil, errors.New("metadata " + MetadataUpload + " must be " + UploadOpen + " or " + UploadChunk)
+	}
+
+	value, ok := e.uploads.Load(metadata[MetadataUploadID])
+	if !ok {
+		return nil, nil, http.StatusNotFound, nil, ErrUploadNotFound
+	}
+	u := value.(*upload)
+	if u.Command != command || u.userID != userID {
+		return nil, nil, http.StatusNotFound, nil, ErrUploadNotFound
+	}
+
+	u.mux.Lock()
+	if u.done {
+		u.mux.Unlock()
+		return e.finishedUpload(ctx, u, kind, metadata, data)
+	}
+	defer u.mux.Unlock()
+	u.active = e.clock.Now()
+	if kind == UploadOpen {
+		return nil, nil, http.StatusOK, u.reply(kind, u.offset), nil
+	}
+
+	offset, err := strconv.ParseInt(metadata[MetadataOffset], 10, 64)
+	switch {
+	case err != nil:
+		return nil, nil, http.StatusBadRequest, u.reply(kind, u.offset), errors.New("metadata " + MetadataOffset + " must be an integer")
+	case offset != u.offset:
+		return nil, nil, http.StatusConflict, u.reply(kind, u.offset), ErrUploadOffset
+	case offset+int64(len(data)) > u.Size:
+		return nil, nil, http.StatusRequestEntityTooLarge, u.reply(kind, u.offset), ErrUploadTooLarge
+	}
+	if _, err = u.buffer.Write(data); err != nil {
+		e.removeUpload(u)
+		e.discardUpload(u)
+		return nil, nil, http.StatusInternalServerError, nil, err
+	}
+	u.hash.Write(data)
+	u.offset += int64(len(data))
+	if u.offset < u.Size {
+		return nil, nil, http.StatusOK, u.reply(kind, u.offset), nil
+	}
+
+	// complete, the upload is kept finished to answer retries of this chunk
+	e.closeUpload(u)
+	code, err := int32(http.StatusOK), error(nil)
+	if u.checksum != "" && !strings.EqualFold(u.checksum, hex.EncodeToString(u.hash.Sum(nil))) {
+		code, err = http.StatusUnprocessableEntity, ErrUploadChecksum
+	} else if u.Reader, err = u.buffer.reader(); err != nil {
+		code = http.StatusInternalServerError
+	}
+	if err != nil {
+		e.discardUpload(u)
+		u.finish(&uploadResult{code: code, message: err.Error(), metadata: u.reply(kind, u.offset)})
+		return nil, nil, code, u.reply(kind, u.offset), err
+	}
+	return u, nil, http.StatusOK, u.reply(kind, u.offset), nil
+}
+
+// finishedUpload answers a frame of an upload that took its last chunk: an
+// open frame with the size as the offset, the last chunk sent again with the
+// reply it got, once there is one.
+func (e *Engine) finishedUpload(ctx context.Context, u *upload, kind string,
+	metadata map[string]string, data []byte) (*upload, *uploadResult, int32, map[string]string, error) {
+	if kind == UploadOpen {
+		if checksum := metadata[MetadataChecksum]; !strings.EqualFold(checksum, u.checksum) {
+			return nil, nil, http.StatusNotFound, nil, ErrUploadNotFound
+		}
+		return nil, nil, http.StatusOK, u.reply(kind, u.Size), nil
+	}
+	offset, err := strconv.ParseInt(metadata[MetadataOffset], 10, 64)
+	if err != nil || offset+int64(len(data)) != u.Size {
+		return nil, nil, http.StatusConflict, u.reply(kind, u.Size), ErrUploadOffset
+	}
+	select {
+	case <-u.finished:
+	case <-ctx.Done():
+		return nil, nil, http.StatusServiceUnavailable, nil, context.Cause(ctx)
+	}
+	return nil, u.result, 0, nil, nil
+}
+
+// openUpload starts an upload of command declared by metadata, it returns the
+// reply code when it fails.
+func (e *Engine) openUpload(command, userID, owner string, config *uploadConfig, metadata map[string]string) (*upload, int32, error) {
+	size, err := strconv.ParseInt(metadata[MetadataSize], 10, 64)
+	switch {
+	case err != nil || size <= 0:
+		return nil, http.StatusBadRequest, errors.New("metadata " + MetadataSize + " must be a positive integer")
+	case config.maxSize > 0 && size > config.maxSize:
+		return nil, http.StatusRequestEntityTooLarge, ErrUploadTooLarge
+	case !config.acquire(owner):
+		return nil, http.StatusTooManyRequests, ErrTooManyUploads
+	}
+
+	var buffer uploadBuffer = &memoryBuffer{}
+	if config.spool {
+		file, err := os.CreateTemp(config.tempDir, "websocket-upload-*")
+		if err != nil {
+			config.release(owner)
+			return nil, http.StatusInternalServerError, err
+		}
+		buffer = fileBuffer{File: file}
+	}
+
+	u := &upload{
+		Upload: Upload{
+			ID:       uuid.NewV4().String(),
+			Command:  command,
+			Size:     size,
+			Metadata: make(map[string]string, len(metadata)),
+		},
+		userID:   userID,
+		owner:    owner,
+		checksum: metadata[MetadataChecksum],
+		hash:     sha256.New(),
+		buffer:   buffer,
+		config:   config,
+		finished: make(chan struct{}),
+		active:   e.clock.Now(),
+	}
+	for key, value := range metadata {
+		if key != MetadataUpload {
+			u.Metadata[key] = value
+		}
+	}
+	e.uploads.Store(u.ID, u)
+	e.wheel.afterFunc(config.ttl, func() {
+		go e.expireUpload(u)
+	})
+	return u, http.StatusOK, nil
+}
+
+// acquire counts an unfinished upload of owner, it reports false over a limit.
+func (config *uploadConfig) acquire(owner string) bool {
+	config.mux.Lock()
+	defer config.mux.Unlock()
+	if config.maxOpen > 0 && config.open >= config.maxOpen ||
+		config.maxOwned > 0 && config.owned[owner] >= config.maxOwned {
+		return false
+	}
+	config.open++
+	config.owned[owner]++
+	return true
+}
+
+// release uncounts an upload of owner that is no longer unfinished.
+func (config *uploadConfig) release(owner string) {
+	config.mux.Lock()
+	defer config.mux.Unlock()
+	config.open--
+	if config.owned[owner]--; config.owned[owner] <= 0 {
+		delete(config.owned, owner)
+	}
+}
+
+// reply returns the metadata of the reply to an upload frame of kind.
+func (u *upload) reply(kind string, offset int64) map[string]string {
+	return map[string]string{
+		MetadataUpload:   kind,
+		MetadataUploadID: u.ID,
+		MetadataOffset:   strconv.FormatInt(offset, 10),
+	}
+}
+
+// closeUpload stops u taking chunks and uncounts it, the caller holds its lock.
+func (e *Engine) closeUpload(u *upload) {
+	if !u.done {
+		u.done = true
+		u.active = e.clock.Now()
+		u.config.release(u.owner)
+	}
+}
+
+// removeUpload makes u unreachable, the caller holds its lock.
+func (e *Engine) removeUpload(u *upload) {
+	e.closeUpload(u)
+	e.uploads.CompareAndDelete(u.ID, u)
+}
+
+// finish records the reply to the chunk completing u, the caller holds its lock.
+func (u *upload) finish(result *uploadResult) {
+	u.result = result
+	close(u.finished)
+}
+
+// complete records the reply of the handler of u.
+func (u *upload) complete(result *uploadResult) {
+	u.mux.Lock()
+	defer u.mux.Unlock()
+	u.finish(result)
+}
+
+// discardUpload releases the buffer of a complete upload once its handler returned.
+func (e *Engine) discardUpload(u *upload) {
+	if err := u.buffer.close(); err != nil {
+		e.log.Warn("upload discard failed", "upload_id", u.ID, "err", err)
+	}
+}
+
+// expireUpload discards u when it had no frame for its ttl, or forgets it
+// once it has been finished for its ttl; else it checks it again when it would expire.
+func (e *Engine) expireUpload(u *upload) {
+	u.mux.Lock()
+	defer u.mux.Unlock()
+	if idle := e.clock.Now().Sub(u.active); idle < u.config.ttl {
+		e.wheel.afterFunc(u.config.ttl-idle, func() {
+			go e.expireUpload(u)
+		})
+		return
+	}
+	if !u.done {
+		e.discardUpload(u)
+	}
+	e.removeUpload(u)
+}
+
+// discardUploads drops the uploads, at shutdown.
+func (e *Engine) discardUploads() {
+	e.uploads.Range(func(key, value any) bool {
+		u := value.(*upload)
+		u.mux.Lock()
+		if !u.done {
+			e.discardUpload(u)
+		}
+		e.removeUpload(u)
+		u.mux.Unlock()
+		return true
+	})
+}
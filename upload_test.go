@@ -0,0 +1,219 @@
+package websocket
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	ws "github.com/gorilla/websocket"
+)
+
+func TestUpload(t *testing.T) {
+	content := []byte("hello, chunked world")
+	sum := sha256.Sum256(content)
+	for _, spool := range []bool{false, true} {
+		dir := t.TempDir()
+		opts := []UploadOption{WithUploadMaxSize(64)}
+		if spool {
+			opts = append(opts, WithUploadTempFile(dir))
+		}
+		e := newTestEngine()
+		received := make(chan string, 1)
+		e.RegisterJsonUploadRouter("avatar", func(ctx context.Context, request *JsonMessage, upload *Upload) error {
+			data, err := io.ReadAll(upload.Reader)
+			if err != nil {
+				return err
+			}
+			if upload.Size != int64(len(content)) || upload.Metadata["name"] != "me.png" {
+				t.Errorf("upload %+v", upload)
+			}
+			received <- string(data)
+			request.Data, request.Metadata = []byte("stored"), map[string]string{"url": "/avatars/me.png"}
+			return nil
+		}, opts...)
+
+		send := func(c *Client, metadata map[string]string, data []byte) JsonMessage {
+			raw, _ := json.Marshal(&JsonMessage{RequestId: "1", SocketId: c.ID(), Command: "avatar", Data: data, Metadata: metadata})
+			c.Dispatch(ws.TextMessage, raw)
+			var reply JsonMessage
+			frames := c.Drain()
+			if len(frames) != 1 {
+				t.Fatalf("%d replies", len(frames))
+			}
+			_ = json.Unmarshal(frames[0], &reply)
+			return reply
+		}
+		chunk := func(c *Client, uploadID string, offset int, data []byte) JsonMessage {
+			return send(c, map[string]string{MetadataUpload: UploadChunk, MetadataUploadID: uploadID,
+				MetadataOffset: strconv.Itoa(offset)}, data)
+		}
+
+		c := e.NewSyntheticClient()
+		if reply := send(c, map[string]string{MetadataUpload: UploadOpen, MetadataSize: "65"}, nil); reply.Code != http.StatusRequestEntityTooLarge {
+			t.Fatalf("oversized open answered %d", reply.Code)
+		}
+		open := map[string]string{MetadataUpload: UploadOpen, MetadataSize: strconv.Itoa(len(content)),
+			MetadataChecksum: strings.ToUpper(hex.EncodeToString(sum[:])), "name": "me.png"}
+		reply := send(c, open, nil)
+		uploadID := reply.Metadata[MetadataUploadID]
+		if reply.Code != http.StatusOK || uploadID == "" || reply.Metadata[MetadataOffset] != "0" {
+			t.Fatalf("open reply %+v", reply)
+		}
+		if reply = chunk(c, uploadID, 0, content[:8]); reply.Code != http.StatusOK || reply.Metadata[MetadataOffset] != "8" {
+			t.Fatalf("chunk reply %+v", reply)
+		}
+		if reply = chunk(c, uploadID, 4, content[4:12]); reply.Code != http.StatusConflict || reply.Metadata[MetadataOffset] != "8" {
+			t.Fatalf("chunk at a wrong offset answered %+v", reply)
+		}
+		if reply = chunk(c, uploadID, 8, make([]byte, 64)); reply.Code != http.StatusRequestEntityTooLarge {
+			t.Fatalf("chunk past the size answered %+v", reply)
+		}
+
+		// the connection is lost, another one resumes the upload
+		_ = e.Kick(c.ID(), 0, "test")
+		c = e.NewSyntheticClient()
+		if reply = chunk(c, "unknown", 0, content); reply.Code != http.StatusNotFound {
+			t.Fatalf("chunk of an unknown upload answered %+v", reply)
+		}
+		open[MetadataUploadID] = uploadID
+		if reply = send(c, open, nil); reply.Code != http.StatusOK || reply.Metadata[MetadataOffset] != "8" {
+			t.Fatalf("resume reply %+v", reply)
+		}
+		if reply = chunk(c, uploadID, 8, content[8:]); reply.Code != http.StatusOK || string(reply.Data) != "stored" ||
+			reply.Metadata[MetadataOffset] != strconv.Itoa(len(content)) || reply.Metadata["url"] != "/avatars/me.png" {
+			t.Fatalf("last chunk reply %+v", reply)
+		}
+		if data := <-received; data != string(content) {
+			t.Fatalf("handler read %q", data)
+		}
+
+		// the reply to the last chunk is lost, the retry gets it again
+		if reply = chunk(c, uploadID, 8, content[8:]); reply.Code != http.StatusOK || string(reply.Data) != "stored" ||
+			reply.Metadata["url"] != "/avatars/me.png" {
+			t.Fatalf("retried last chunk reply %+v", reply)
+		}
+		if reply = send(c, open, nil); reply.Code != http.StatusOK || reply.Metadata[MetadataOffset] != strconv.Itoa(len(content)) {
+			t.Fatalf("finished upload opened again: %+v", reply)
+		}
+		if reply = chunk(c, uploadID, len(content), nil); reply.Code != http.StatusOK || string(reply.Data) != "stored" {
+			t.Fatalf("empty chunk at the end of a finished upload answered %+v", reply)
+		}
+		if reply = chunk(c, uploadID, 0, content[:8]); reply.Code != http.StatusConflict {
+			t.Fatalf("early chunk of a finished upload answered %+v", reply)
+		}
+		if len(received) != 0 {
+			t.Fatal("handler called again for a retried chunk")
+		}
+		open[MetadataChecksum] = "other"
+		if reply = send(c, open, nil); reply.Code != http.StatusNotFound {
+			t.Fatalf("finished upload opened with another checksum: %+v", reply)
+		}
+		if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+			t.Fatalf("%d temporary files left", len(entries))
+		}
+
+		delete(open, MetadataUploadID)
+		open[MetadataChecksum] = hex.EncodeToString(make([]byte, sha256.Size))
+		uploadID = send(c, open, nil).Metadata[MetadataUploadID]
+		if reply = chunk(c, uploadID, 0, content); reply.Code != http.StatusUnprocessableEntity {
+			t.Fatalf("corrupted upload answered %+v", reply)
+		}
+		if reply = chunk(c, uploadID, 0, content); reply.Code != http.StatusUnprocessableEntity {
+			t.Fatalf("retried corrupted upload answered %+v", reply)
+		}
+		_ = e.Shutdown(t.Context())
+	}
+}
+
+func TestUploadExpires(t *testing.T) {
+	e := newTestEngine(WithTimingWheel(time.Millisecond, 16))
+	defer e.Shutdown(t.Context())
+	e.RegisterJsonUploadRouter("avatar", func(ctx context.Context, request *JsonMessage, upload *Upload) error {
+		return nil
+	}, WithUploadTTL(20*time.Millisecond))
+
+	c := e.NewSyntheticClient()
+	raw, _ := json.Marshal(&JsonMessage{RequestId: "1", SocketId: c.ID(), Command: "avatar",
+		Metadata: map[string]string{MetadataUpload: UploadOpen, MetadataSize: "10"}})
+	c.Dispatch(ws.TextMessage, raw)
+	c.Drain()
+
+	count := func() (n int) {
+		e.uploads.Range(func(key, value any) bool {
+			n++
+			return true
+		})
+		return
+	}
+	if count() != 1 {
+		t.Fatal("upload not kept")
+	}
+	for deadline := time.Now().Add(time.Second); count() != 0 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	if count() != 0 {
+		t.Fatal("idle upload not discarded")
+	}
+
+	// a finished upload is remembered for the ttl, then forgotten
+	c.Dispatch(ws.TextMessage, raw)
+	var reply JsonMessage
+	_ = json.Unmarshal(c.Drain()[0], &reply)
+	raw, _ = json.Marshal(&JsonMessage{RequestId: "2", SocketId: c.ID(), Command: "avatar", Data: make([]byte, 10),
+		Metadata: map[string]string{MetadataUpload: UploadChunk, MetadataUploadID: reply.Metadata[MetadataUploadID], MetadataOffset: "0"}})
+	c.Dispatch(ws.TextMessage, raw)
+	if _ = json.Unmarshal(c.Drain()[0], &reply); reply.Code != http.StatusOK || count() != 1 {
+		t.Fatalf("finished upload answered %+v, %d uploads kept", reply, count())
+	}
+	for deadline := time.Now().Add(time.Second); count() != 0 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	if count() != 0 {
+		t.Fatal("finished upload not forgotten")
+	}
+}
+
+func TestUploadLimits(t *testing.T) {
+	e := newTestEngine()
+	defer e.Shutdown(t.Context())
+	e.RegisterJsonUploadRouter("avatar", func(ctx context.Context, request *JsonMessage, upload *Upload) error {
+		return nil
+	}, WithMaxUploads(2, 3))
+
+	open := func(c *Client) JsonMessage {
+		raw, _ := json.Marshal(&JsonMessage{RequestId: "1", SocketId: c.ID(), Command: "avatar",
+			Metadata: map[string]string{MetadataUpload: UploadOpen, MetadataSize: "1"}})
+		c.Dispatch(ws.TextMessage, raw)
+		var reply JsonMessage
+		_ = json.Unmarshal(c.Drain()[0], &reply)
+		return reply
+	}
+	first, second := e.NewSyntheticClient(), e.NewSyntheticClient()
+	uploadID := open(first).Metadata[MetadataUploadID]
+	open(first)
+	if reply := open(first); reply.Code != http.StatusTooManyRequests {
+		t.Fatalf("third upload of a client answered %+v", reply)
+	}
+	if reply := open(second); reply.Code != http.StatusOK {
+		t.Fatalf("upload of another client answered %+v", reply)
+	}
+	if reply := open(second); reply.Code != http.StatusTooManyRequests {
+		t.Fatalf("fourth upload of the engine answered %+v", reply)
+	}
+
+	raw, _ := json.Marshal(&JsonMessage{RequestId: "2", SocketId: first.ID(), Command: "avatar", Data: []byte{1},
+		Metadata: map[string]string{MetadataUpload: UploadChunk, MetadataUploadID: uploadID, MetadataOffset: "0"}})
+	first.Dispatch(ws.TextMessage, raw)
+	first.Drain()
+	if reply := open(second); reply.Code != http.StatusOK {
+		t.Fatalf("upload once another finished answered %+v", reply)
+	}
+}
@@ -0,0 +1,174 @@
+package websocket
+
+import (
+	"errors"
+	"github.com/gorilla/websocket"
+	"sync"
+)
+
+// UserConnPolicy decides what happens when a user exceeds the per-user connection limit.
+type UserConnPolicy int
+
+const (
+	RejectNewest UserConnPolicy = iota // refuse the new connection
+	EvictOldest                        // kick the user's oldest connections
+)
+
+// ErrUserConnLimit is returned when RejectNewest refuses a connection.
+var ErrUserConnLimit = errors.New("too many connections for this user")
+
+// userIndex maps authenticated user ids to all of their connections.
+type userIndex struct {
+	mux    *sync.Mutex
+	limit  int
+	policy UserConnPolicy
+	users  map[string][]*Client // user id -> connections, oldest first
+	bound  map[string]string    // socket id -> user id
+}
+
+func newUserIndex() *userIndex {
+	return &userIndex{
+		mux:   new(sync.Mutex),
+		users: make(map[string][]*Client),
+		bound: make(map[string]string),
+	}
+}
+
+// bind indexes client under userID, enforcing the per-user limit. The returned
+// clients were evicted by EvictOldest and must be kicked by the caller.
+func (u *userIndex) bind(client *Client, userID string) (evicted []*Client, err error) {
+	u.mux.Lock()
+	defer u.mux.Unlock()
+
+	if u.bound[client.id] == userID {
+		return nil, nil
+	}
+	if u.limit > 0 && len(u.users[userID]) >= u.limit {
+		if u.policy == RejectNewest {
+			return nil, ErrUserConnLimit
+		}
+		n := len(u.users[userID]) - u.limit + 1
+		evicted = append(evicted, u.users[userID][:n]...)
+		for _, old := range evicted {
+			u.remove(old)
+		}
+	}
+	u.remove(client)
+	u.users[userID] = append(u.users[userID], client)
+	u.bound[client.id] = userID
+	client.user.Store(userID)
+	return
+}
+
+// unbind drops client from the index, it returns the user id when it was the user's last connection.
+func (u *userIndex) unbind(client *Client) string {
+	u.mux.Lock()
+	defer u.mux.Unlock()
+	userID := u.bound[client.id]
+	u.remove(client)
+	if _, ok := u.users[userID]; userID != "" && !ok {
+		return userID
+	}
+	return ""
+}
+
+// remove drops client from the index, the caller must hold u.mux.
+func (u *userIndex) remove(client *Client) {
+	userID, ok := u.bound[client.id]
+	if !ok {
+		return
+	}
+	delete(u.bound, client.id)
+
+	conns := u.users[userID]
+	for i, conn := range conns {
+		if conn == client {
+			conns = append(conns[:i:i], conns[i+1:]...)
+			break
+		}
+	}
+	if len(conns) == 0 {
+		delete(u.users, userID)
+		return
+	}
+	u.users[userID] = conns
+}
+
+// clients returns the connections of userID, oldest first.
+func (u *userIndex) clients(userID string) []*Client {
+	u.mux.Lock()
+	defer u.mux.Unlock()
+	return append([]*Client(nil), u.users[userID]...)
+}
+
+// evictReason is recorded on connections kicked by EvictOldest.
+func evictReason() DisconnectReason {
+	return DisconnectReason{Kind: DisconnectKick, Code: websocket.ClosePolicyViolation, Text: "replaced by a newer connection"}
+}
+
+// BindUser binds a registered connection to an authenticated user, e.g. after a
+// login command. The per-user connection policy applies.
+func (e *Engine) BindUser(id, userID string) error {
+	client, err := e.getClient(id)
+	if err != nil {
+		return err
+	}
+	evicted, err := e.users.bind(client, userID)
+	if err != nil {
+		return err
+	}
+	for _, old := range evicted {
+		old.closeWith(evictReason())
+	}
+	return nil
+}
+
+// UserClients returns the ids of all connections of a user, oldest first.
+func (e *Engine) UserClients(userID string) []string {
+	clients := e.users.clients(userID)
+	ids := make([]string, 0, len(clients))
+	for _, client := range clients {
+		ids = append(ids, client.id)
+	}
+	return ids
+}
+
+// Kick closes a connection with the given close code (ClosePolicyViolation when 0) and reason.
+func (e *Engine) Kick(id string, code int, reason string) error {
+	client, err := e.getClient(id)
+	if err != nil {
+		return err
+	}
+	client.kick(code, reason)
+	return nil
+}
+
+// KickUser closes all connections of a user and returns how many were kicked.
+func (e *Engine) KickUser(userID string, code int, reason string) int {
+	clients := e.users.clients(userID)
+	for _, client := range clients {
+		client.kick(code, reason)
+	}
+	return len(clients)
+}
+
+// kick closes the client on behalf of the server.
+func (c *Client) kick(code int, reason string) {
+	if code == 0 {
+		code = websocket.ClosePolicyViolation
+	}
+	c.closeWith(DisconnectReason{Kind: DisconnectKick, Code: code, Text: reason})
+}
+
+// SetUser records the authenticated user of the connection. It is meant for
+// OnConnect hooks, the user is indexed when the client is registered; use
+// Engine.BindUser for clients that are already registered.
+func (c *Client) SetUser(userID string) {
+	c.user.Store(userID)
+}
+
+// UserID returns the authenticated user of the connection, if any.
+func (c *Client) UserID() string {
+	userID, _ := c.user.Load().(string)
+	return userID
+}
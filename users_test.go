@@ -0,0 +1,125 @@
+package websocket
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"testing"
+
+	ws "github.com/gorilla/websocket"
+)
+
+func TestUserIndex(t *testing.T) {
+	e := newTestEngine()
+	defer e.Shutdown(t.Context())
+	phone, laptop, other := e.NewSyntheticClient(), e.NewSyntheticClient(), e.NewSyntheticClient()
+	for _, c := range []*Client{phone, laptop, phone} { // binding twice is a no-op
+		if err := e.BindUser(c.ID(), "alice"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := e.BindUser(other.ID(), "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if ids := e.UserClients("alice"); !slices.Equal(ids, []string{phone.ID(), laptop.ID()}) {
+		t.Fatalf("alice has %q", ids)
+	}
+	if err := e.BindUser("missing", "alice"); err == nil {
+		t.Fatal("bound an unknown connection")
+	}
+
+	if err := e.BindUser(laptop.ID(), "bob"); err != nil { // moves to bob
+		t.Fatal(err)
+	}
+	if ids := e.UserClients("bob"); !slices.Equal(ids, []string{other.ID(), laptop.ID()}) || laptop.UserID() != "bob" {
+		t.Fatalf("bob has %q", ids)
+	}
+	if n := e.KickUser("bob", 0, "logged out"); n != 2 {
+		t.Fatalf("kicked %d connections of bob", n)
+	}
+	if reason := laptop.DisconnectReason(); reason.Kind != DisconnectKick || reason.Code != ws.ClosePolicyViolation {
+		t.Fatalf("kicked with %s", reason)
+	}
+	if ids := e.UserClients("bob"); len(ids) != 0 {
+		t.Fatalf("released connections still indexed: %q", ids)
+	}
+
+	if err := e.Kick(phone.ID(), 0, "bye"); err != nil {
+		t.Fatal(err)
+	}
+	e.users.mux.Lock()
+	defer e.users.mux.Unlock()
+	if len(e.users.users) != 0 || len(e.users.bound) != 0 {
+		t.Fatalf("index left with %v %v", e.users.users, e.users.bound)
+	}
+}
+
+// dialUser opens a connection the OnConnect hook binds to the user of its token.
+func dialUser(t *testing.T, url, token string) *ws.Conn {
+	t.Helper()
+	conn, _, err := ws.DefaultDialer.Dial(url+"?token="+token, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+	})
+	return conn
+}
+
+func setUser(c *Client, r *http.Request) error {
+	c.SetUser(r.URL.Query().Get("token"))
+	return nil
+}
+
+func TestUserConnRejectNewest(t *testing.T) {
+	e := newTestEngine(WithMaxConnPerUser(1, RejectNewest), WithOnConnect(setUser))
+	defer e.Shutdown(t.Context())
+	url, clients := serveEngine(t, e)
+
+	dialUser(t, url, "alice")
+	first := <-clients
+	conn := dialUser(t, url, "alice")
+	_, _, err := conn.ReadMessage()
+	var closeErr *ws.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != ws.ClosePolicyViolation || closeErr.Text != ErrUserConnLimit.Error() {
+		t.Fatalf("second connection read %v", err)
+	}
+	<-clients
+	if ids := e.UserClients("alice"); !slices.Equal(ids, []string{first.ID()}) {
+		t.Fatalf("alice has %q", ids)
+	}
+
+	other := e.NewSyntheticClient()
+	if err = e.BindUser(other.ID(), "alice"); !errors.Is(err, ErrUserConnLimit) {
+		t.Fatalf("BindUser over the limit returned %v", err)
+	}
+}
+
+func TestUserConnEvictOldest(t *testing.T) {
+	e := newTestEngine(WithMaxConnPerUser(2, EvictOldest), WithOnConnect(setUser))
+	defer e.Shutdown(t.Context())
+	url, clients := serveEngine(t, e)
+
+	oldest := dialUser(t, url, "alice")
+	evicted := <-clients
+	dialUser(t, url, "alice")
+	second := <-clients
+	dialUser(t, url, "alice")
+	third := <-clients
+
+	var err error
+	for err == nil {
+		_, _, err = oldest.ReadMessage()
+	}
+	var closeErr *ws.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != ws.ClosePolicyViolation || closeErr.Text != evictReason().Text {
+		t.Fatalf("oldest connection read %v", err)
+	}
+	if reason := evicted.DisconnectReason(); reason != evictReason() {
+		t.Fatalf("evicted with %s", reason)
+	}
+	if ids := e.UserClients("alice"); !slices.Equal(ids, []string{second.ID(), third.ID()}) {
+		t.Fatalf("alice has %q", ids)
+	}
+}
@@ -0,0 +1,90 @@
+package wstest
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// FakeClock is a websocket.Clock moved by hand, give it to the engine with
+// websocket.WithClock to test heartbeats, pings and rate limits without waiting.
+type FakeClock struct {
+	mux     sync.Mutex
+	now     time.Time
+	tickers []*fakeTicker
+}
+
+type fakeTicker struct {
+	period time.Duration
+	next   time.Time
+	c      chan time.Time
+	stop   chan struct{}
+}
+
+// NewFakeClock returns a clock standing at now.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+// Now returns the current time of the clock.
+func (c *FakeClock) Now() time.Time {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.now
+}
+
+// NewTicker returns a ticker firing every d of Advance.
+func (c *FakeClock) NewTicker(d time.Duration) (<-chan time.Time, func()) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	ticker := &fakeTicker{period: d, next: c.now.Add(d), c: make(chan time.Time), stop: make(chan struct{})}
+	c.tickers = append(c.tickers, ticker)
+	var once sync.Once
+	return ticker.c, func() {
+		once.Do(func() {
+			c.remove(ticker)
+			close(ticker.stop)
+		})
+	}
+}
+
+// Advance moves the clock forward by d. Every tick due meanwhile is delivered
+// in time order, each one waiting for its receiver, so the timing wheel has
+// taken all of them when Advance returns; the tasks they fire run on the
+// wheel goroutine and may still be in progress.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mux.Lock()
+	end := c.now.Add(d)
+	for {
+		sort.Slice(c.tickers, func(i, j int) bool {
+			return c.tickers[i].next.Before(c.tickers[j].next)
+		})
+		if len(c.tickers) == 0 || c.tickers[0].next.After(end) {
+			break
+		}
+		ticker := c.tickers[0]
+		c.now = ticker.next
+		ticker.next = ticker.next.Add(ticker.period)
+		now := c.now
+		c.mux.Unlock()
+		select {
+		case ticker.c <- now:
+		case <-ticker.stop:
+		}
+		c.mux.Lock()
+	}
+	c.now = end
+	c.mux.Unlock()
+}
+
+// remove forgets a stopped ticker.
+func (c *FakeClock) remove(ticker *fakeTicker) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	for i, t := range c.tickers {
+		if t == ticker {
+			c.tickers = append(c.tickers[:i], c.tickers[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package wstest
+
+import (
+	"errors"
+	"github.com/gin-generator/websocket"
+	"github.com/gin-generator/websocket/client"
+	ws "github.com/gorilla/websocket"
+	"github.com/satori/go.uuid"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Message is a decoded envelope, whatever the codec.
+type Message = client.Message
+
+// Conn is a test connection. Its expectations read the messages in the order
+// they arrive, keeping the ones they skip for the next expectations, and fail
+// the test when nothing matches in time.
+type Conn struct {
+	t        testing.TB
+	conn     *ws.Conn
+	codec    client.Codec
+	header   http.Header
+	timeout  time.Duration
+	socketID string
+
+	frames  chan frame // filled by the read loop, closed after the read error
+	done    chan struct{}
+	once    sync.Once
+	pending []*Message // received but not expected yet
+	err     error      // read error once frames is closed
+}
+
+type frame struct {
+	messageType int
+	data        []byte
+	err         error
+}
+
+func dial(t testing.TB, url string, opts ...Option) *Conn {
+	t.Helper()
+	c := &Conn{t: t, codec: client.JSON, timeout: Timeout, frames: make(chan frame, 256), done: make(chan struct{})}
+	for _, opt := range opts {
+		opt.apply(c)
+	}
+
+	dialer := *ws.DefaultDialer
+	dialer.Subprotocols = []string{c.codec.Subprotocol()}
+	conn, _, err := dialer.Dial(url, c.header)
+	if err != nil {
+		t.Fatalf("wstest: dial %s: %v", url, err)
+	}
+	c.conn = conn
+	t.Cleanup(func() {
+		_ = c.Close()
+	})
+	go c.read()
+
+	c.socketID = c.Expect(func(m *Message) bool {
+		return m.Command == websocket.Connected
+	}).SocketId
+	return c
+}
+
+// read forwards the frames of the connection until it fails.
+func (c *Conn) read() {
+	defer close(c.frames)
+	for {
+		messageType, data, err := c.conn.ReadMessage()
+		select {
+		case c.frames <- frame{messageType: messageType, data: data, err: err}:
+		case <-c.done:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// SocketID returns the id the engine gave the connection.
+func (c *Conn) SocketID() string {
+	return c.socketID
+}
+
+// Send sends a command envelope and returns its request id.
+func (c *Conn) Send(command string, data []byte) string {
+	c.t.Helper()
+	message := &Message{
+		RequestId: uuid.NewV4().String(),
+		SocketId:  c.socketID,
+		Command:   command,
+		Data:      data,
+	}
+	c.SendMessage(message)
+	return message.RequestId
+}
+
+// SendMessage sends message as is, e.g. with metadata or an invalid field.
+func (c *Conn) SendMessage(message *Message) {
+	c.t.Helper()
+	data, err := c.codec.Encode(message)
+	if err != nil {
+		c.t.Fatalf("wstest: encode %s: %v", message.Command, err)
+	}
+	if err = c.conn.WriteMessage(c.codec.MessageType(), data); err != nil {
+		c.t.Fatalf("wstest: send %s: %v", message.Command, err)
+	}
+}
+
+// Call sends a command envelope and returns the reply to it, the end frame of a stream.
+func (c *Conn) Call(command string, data []byte) *Message {
+	c.t.Helper()
+	requestID := c.Send(command, data)
+	return c.Expect(func(m *Message) bool {
+		return m.RequestId == requestID && isReply(m)
+	})
+}
+
+// ExpectReply returns the next reply to command, published messages and stream chunks excluded.
+func (c *Conn) ExpectReply(command string) *Message {
+	c.t.Helper()
+	return c.Expect(func(m *Message) bool {
+		return m.Command == command && isReply(m)
+	})
+}
+
+// ExpectChunk returns the next stream chunk of the request requestID.
+func (c *Conn) ExpectChunk(requestID string) *Message {
+	c.t.Helper()
+	return c.Expect(func(m *Message) bool {
+		return m.RequestId == requestID && m.Metadata[websocket.MetadataStream] == websocket.StreamChunk
+	})
+}
+
+// isReply reports whether m is the reply to a request.
+func isReply(m *Message) bool {
+	return m.Metadata[websocket.MetadataChannel] == "" && m.Metadata[websocket.MetadataStream] != websocket.StreamChunk
+}
+
+// ExpectPush returns the next message published on channel.
+func (c *Conn) ExpectPush(channel string) *Message {
+	c.t.Helper()
+	return c.Expect(func(m *Message) bool {
+		return m.Metadata[websocket.MetadataChannel] == channel
+	})
+}
+
+// Expect returns the next message match accepts.
+func (c *Conn) Expect(match func(m *Message) bool) *Message {
+	c.t.Helper()
+	for i, message := range c.pending {
+		if match(message) {
+			c.pending = append(c.pending[:i], c.pending[i+1:]...)
+			return message
+		}
+	}
+
+	timer := time.NewTimer(c.timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case f, ok := <-c.frames:
+			if !ok || f.err != nil {
+				c.setErr(f.err)
+				c.t.Fatalf("wstest: connection closed while expecting a message: %v", c.err)
+			}
+			messages, err := client.Decode(f.messageType, f.data)
+			if err != nil {
+				c.t.Fatalf("wstest: decode %q: %v", f.data, err)
+			}
+			var found *Message
+			for _, message := range messages {
+				if found == nil && match(message) {
+					found = message
+				} else {
+					c.pending = append(c.pending, message)
+				}
+			}
+			if found != nil {
+				return found
+			}
+		case <-timer.C:
+			c.t.Fatalf("wstest: no matching message in %s, %d unexpected", c.timeout, len(c.pending))
+		}
+	}
+}
+
+// ExpectClosed waits for the engine to close the connection, skipping the
+// messages left, and returns the close code and text.
+func (c *Conn) ExpectClosed() (code int, text string) {
+	c.t.Helper()
+	timer := time.NewTimer(c.timeout)
+	defer timer.Stop()
+	for c.err == nil {
+		select {
+		case f, ok := <-c.frames:
+			if !ok || f.err != nil {
+				c.setErr(f.err)
+			}
+		case <-timer.C:
+			c.t.Fatalf("wstest: connection still open after %s", c.timeout)
+		}
+	}
+	var closeErr *ws.CloseError
+	if !errors.As(c.err, &closeErr) {
+		c.t.Fatalf("wstest: connection failed without a close frame: %v", c.err)
+	}
+	return closeErr.Code, closeErr.Text
+}
+
+// setErr records the read error, err is nil once frames has been drained.
+func (c *Conn) setErr(err error) {
+	if c.err == nil {
+		c.err = err
+	}
+	if c.err == nil {
+		c.err = errors.New("connection closed")
+	}
+}
+
+// Close sends a normal close frame and closes the connection.
+func (c *Conn) Close() (err error) {
+	c.once.Do(func() {
+		close(c.done)
+		_ = c.conn.WriteControl(ws.CloseMessage, ws.FormatCloseMessage(ws.CloseNormalClosure, ""),
+			time.Now().Add(time.Second))
+		err = c.conn.Close()
+	})
+	return
+}
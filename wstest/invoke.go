@@ -0,0 +1,53 @@
+package wstest
+
+import (
+	"context"
+	"github.com/gin-generator/websocket"
+	"github.com/gin-generator/websocket/client"
+	ws "github.com/gorilla/websocket"
+	"github.com/satori/go.uuid"
+	"testing"
+)
+
+// Invoke runs a command envelope through the routes of engine on a synthetic
+// client, without any network, and returns the reply. The client uses the
+// proto codec when opts include websocket.WithProtocol(BinaryMessage); it is
+// released before Invoke returns. The reply of an async command is waited for
+// up to Timeout, the reply of a stream command is its final frame, its chunks
+// are skipped.
+func Invoke(t testing.TB, engine *websocket.Engine, command string, data []byte, opts ...websocket.Option) *Message {
+	t.Helper()
+	c := engine.NewSyntheticClient(opts...)
+	defer func() {
+		_ = engine.Kick(c.ID(), ws.CloseNormalClosure, "")
+	}()
+
+	codec := client.JSON
+	if c.Protocol() == ws.BinaryMessage {
+		codec = client.Proto
+	}
+	request := &Message{RequestId: uuid.NewV4().String(), SocketId: c.ID(), Command: command, Data: data}
+	raw, err := codec.Encode(request)
+	if err != nil {
+		t.Fatalf("wstest: encode %s: %v", command, err)
+	}
+	c.Dispatch(codec.MessageType(), raw)
+
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+	for {
+		frame, err := c.Next(ctx)
+		if err != nil {
+			t.Fatalf("wstest: %s sent no reply: %v", command, err)
+		}
+		messages, err := client.Decode(codec.MessageType(), frame)
+		if err != nil {
+			t.Fatalf("wstest: decode %q: %v", frame, err)
+		}
+		for _, message := range messages {
+			if message.RequestId == request.RequestId && message.Metadata[websocket.MetadataStream] != websocket.StreamChunk {
+				return message
+			}
+		}
+	}
+}
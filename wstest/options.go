@@ -0,0 +1,39 @@
+package wstest
+
+import (
+	"github.com/gin-generator/websocket/client"
+	"net/http"
+	"time"
+)
+
+type (
+	Option interface {
+		apply(*Conn)
+	}
+	optionFunc func(*Conn)
+)
+
+func (f optionFunc) apply(c *Conn) {
+	f(c)
+}
+
+// WithCodec sets the envelope codec of the connection, JSON by default.
+func WithCodec(codec client.Codec) Option {
+	return optionFunc(func(c *Conn) {
+		c.codec = codec
+	})
+}
+
+// WithHeader sets the HTTP headers of the handshake.
+func WithHeader(header http.Header) Option {
+	return optionFunc(func(c *Conn) {
+		c.header = header
+	})
+}
+
+// WithTimeout sets how long expectations wait, Timeout by default.
+func WithTimeout(timeout time.Duration) Option {
+	return optionFunc(func(c *Conn) {
+		c.timeout = timeout
+	})
+}
@@ -0,0 +1,55 @@
+// Package wstest runs an Engine in process for tests: NewServer serves it
+// behind an httptest.Server and dials test connections with expectation
+// helpers, FakeClock drives heartbeats and timeouts by hand, and Invoke runs
+// a handler on a synthetic client without any network.
+package wstest
+
+import (
+	"context"
+	"github.com/gin-generator/websocket"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Timeout is the default time an expectation waits for its message.
+const Timeout = 5 * time.Second
+
+// Path is the route the engine is served on.
+const Path = "/ws"
+
+// Server is an Engine served by an httptest.Server.
+type Server struct {
+	Engine *websocket.Engine
+	HTTP   *httptest.Server
+}
+
+// NewServer serves engine on Path with the client options opts. The engine is
+// shut down and the server closed when the test ends.
+func NewServer(t testing.TB, engine *websocket.Engine, opts ...websocket.Option) *Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.Handle(Path, engine.Handler(opts...))
+	s := &Server{Engine: engine, HTTP: httptest.NewServer(mux)}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+		defer cancel()
+		_ = engine.Shutdown(ctx)
+		s.HTTP.Close()
+	})
+	return s
+}
+
+// URL returns the websocket URL of the engine.
+func (s *Server) URL() string {
+	return "ws" + strings.TrimPrefix(s.HTTP.URL, "http") + Path
+}
+
+// Dial connects to the engine and waits for the connected greeting. The
+// connection is closed when the test ends.
+func (s *Server) Dial(t testing.TB, opts ...Option) *Conn {
+	t.Helper()
+	return dial(t, s.URL(), opts...)
+}
@@ -0,0 +1,115 @@
+package wstest
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-generator/websocket"
+	"github.com/gin-generator/websocket/client"
+	ws "github.com/gorilla/websocket"
+)
+
+func newEngine(opts ...websocket.EngineOption) *websocket.Engine {
+	e := websocket.NewEngineWithOptions(append([]websocket.EngineOption{websocket.WithSlog(slog.New(slog.DiscardHandler))}, opts...)...)
+	e.RegisterJsonRouter("echo", func(m *websocket.JsonMessage) {
+		m.Code, m.Message = http.StatusOK, string(m.Data)
+	})
+	e.RegisterProtoRouter("echo", func(m *websocket.ProtoMessage) {
+		m.Code, m.Message = http.StatusOK, string(m.Data)
+	})
+	e.RegisterSubscribeRouter()
+	return e
+}
+
+func TestConn(t *testing.T) {
+	for _, codec := range []client.Codec{client.JSON, client.Proto} {
+		s := NewServer(t, newEngine())
+		conn := s.Dial(t, WithCodec(codec))
+		if conn.SocketID() == "" {
+			t.Fatal("no socket id")
+		}
+
+		if reply := conn.Call(websocket.SubscribeCommand, []byte("news")); reply.Code != http.StatusOK {
+			t.Fatalf("subscribe: %+v", reply)
+		}
+		if err := s.Engine.PublishMessage("news", "headline", []byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+		conn.Send("echo", []byte("ping"))
+		if reply := conn.ExpectReply("echo"); reply.Message != "ping" {
+			t.Fatalf("codec %d: echo replied %+v", codec, reply)
+		}
+		if push := conn.ExpectPush("news"); push.Command != "headline" || string(push.Data) != "hello" {
+			t.Fatalf("codec %d: push %+v", codec, push)
+		}
+
+		if err := s.Engine.Kick(conn.SocketID(), ws.ClosePolicyViolation, "bye"); err != nil {
+			t.Fatal(err)
+		}
+		if code, text := conn.ExpectClosed(); code != ws.ClosePolicyViolation || text != "bye" {
+			t.Fatalf("closed with %d %q", code, text)
+		}
+	}
+}
+
+func TestFakeClockIdleTimeout(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	s := NewServer(t, newEngine(websocket.WithClock(clock)), websocket.WithBreakTime(30))
+	conn := s.Dial(t)
+
+	clock.Advance(20 * time.Second)
+	conn.Call("echo", nil) // activity pushes the deadline back
+	clock.Advance(20 * time.Second)
+	conn.Call("echo", nil)
+
+	clock.Advance(31 * time.Second)
+	if code, text := conn.ExpectClosed(); code != ws.CloseNormalClosure || text != "idle timeout" {
+		t.Fatalf("closed with %d %q", code, text)
+	}
+}
+
+func TestInvoke(t *testing.T) {
+	e := newEngine()
+	defer e.Shutdown(t.Context())
+
+	if reply := Invoke(t, e, "echo", []byte("json")); reply.Code != http.StatusOK || reply.Message != "json" {
+		t.Fatalf("json reply %+v", reply)
+	}
+	if reply := Invoke(t, e, "echo", []byte("proto"), websocket.WithProtocol(ws.BinaryMessage)); reply.Message != "proto" {
+		t.Fatalf("proto reply %+v", reply)
+	}
+	if reply := Invoke(t, e, "missing", nil); reply.Code != http.StatusBadRequest {
+		t.Fatalf("unknown command replied %+v", reply)
+	}
+	if n := e.Stats().Connections; n != 0 {
+		t.Fatalf("%d synthetic clients left", n)
+	}
+}
+
+func TestInvokeAsyncAndStream(t *testing.T) {
+	e := newEngine()
+	defer e.Shutdown(t.Context())
+	e.RegisterJsonRouter("slow", func(m *websocket.JsonMessage) {
+		time.Sleep(10 * time.Millisecond)
+		m.Code, m.Message = http.StatusOK, "slow"
+	}, websocket.WithAsync())
+	e.RegisterJsonStreamRouter("count", func(ctx context.Context, m *websocket.JsonMessage, stream *websocket.Stream) error {
+		for i := range 2 * websocket.StreamWindow {
+			if err := stream.Send([]byte{byte(i)}); err != nil {
+				return err
+			}
+		}
+		m.Code, m.Message = http.StatusOK, "counted"
+		return nil
+	})
+
+	if reply := Invoke(t, e, "slow", nil); reply.Code != http.StatusOK || reply.Message != "slow" {
+		t.Fatalf("async reply %+v", reply)
+	}
+	if reply := Invoke(t, e, "count", nil); reply.Message != "counted" || reply.Metadata[websocket.MetadataStream] != websocket.StreamEnd {
+		t.Fatalf("stream reply %+v", reply)
+	}
+}